@@ -0,0 +1,79 @@
+package re
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultMatchCacheSize is the number of compiled patterns that the package-level functions keep by default.
+const DefaultMatchCacheSize = 64
+
+// regexpCache is a least recently used cache of compiled patterns, keyed by the pattern string.
+// It is safe for concurrent use.
+type regexpCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *Regexp, the most recently used first
+	entries map[string]*list.Element
+}
+
+// matchCache holds the patterns compiled by Match and MatchIndex.
+var matchCache = newRegexpCache(DefaultMatchCacheSize)
+
+// newRegexpCache returns an empty cache that holds at most size patterns.
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// compile returns the compiled form of pattern, compiling it only if it is not in the cache yet.
+// Patterns that fail to compile are not cached.
+func (c *regexpCache) compile(pattern string) (*Regexp, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*Regexp), nil
+	}
+	c.mu.Unlock()
+
+	// Compile outside the lock; if another goroutine adds the same pattern meanwhile, the entry is just replaced.
+	re, err := Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[pattern]; ok {
+		c.order.Remove(elem)
+	}
+	if c.size > 0 {
+		c.entries[pattern] = c.order.PushFront(re)
+		c.evict()
+	}
+	return re, nil
+}
+
+// resize changes the number of patterns the cache holds, dropping the least recently used ones that no longer fit.
+func (c *regexpCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+}
+
+// evict drops the least recently used patterns until the cache is within its size. The caller holds the lock.
+func (c *regexpCache) evict() {
+	for c.order.Len() > max(c.size, 0) {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Regexp).pattern)
+	}
+}
+
+// SetMatchCacheSize sets how many compiled patterns Match and MatchIndex keep, so that calling them repeatedly
+// with the same pattern does not parse it and build its NFA every time. A size of zero or less disables the cache.
+// The default is DefaultMatchCacheSize.
+func SetMatchCacheSize(size int) {
+	matchCache.resize(size)
+}
@@ -0,0 +1,87 @@
+package re
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegexpCache(t *testing.T) {
+	cache := newRegexpCache(2)
+	a, _ := cache.compile("a")
+	b, _ := cache.compile("b")
+	if again, _ := cache.compile("a"); again != a {
+		t.Errorf("compile(%q) did not return the cached Regexp", "a")
+	}
+
+	// "b" is now the least recently used pattern, so adding "c" evicts it.
+	cache.compile("c")
+	if again, _ := cache.compile("b"); again == b {
+		t.Errorf("compile(%q) returned an evicted Regexp", "b")
+	}
+	// Compiling "b" again in turn evicted "a".
+	if _, ok := cache.entries["a"]; ok {
+		t.Errorf("cache still holds %q after it became the least recently used", "a")
+	}
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Errorf("cache holds %d patterns; want 2", cache.order.Len())
+	}
+
+	if _, err := cache.compile("(a"); err == nil {
+		t.Errorf("compile(%q) returned no error", "(a")
+	} else if _, ok := cache.entries["(a"]; ok {
+		t.Errorf("cache holds the invalid pattern %q", "(a")
+	}
+
+	cache.resize(0)
+	if cache.order.Len() != 0 || len(cache.entries) != 0 {
+		t.Errorf("cache holds %d patterns after resizing to 0", cache.order.Len())
+	}
+	if first, _ := cache.compile("a"); first == nil {
+		t.Errorf("compile(%q) with the cache disabled returned nil", "a")
+	} else if second, _ := cache.compile("a"); second == first {
+		t.Errorf("compile(%q) with the cache disabled returned a cached Regexp", "a")
+	}
+}
+
+func TestRegexpCacheConcurrent(t *testing.T) {
+	cache := newRegexpCache(4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				pattern := fmt.Sprintf("a{%d}", (g+i)%6)
+				if re, err := cache.compile(pattern); err != nil || re.String() != pattern {
+					t.Errorf("compile(%q) = %v, %v", pattern, re, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cache.order.Len() > 4 || cache.order.Len() != len(cache.entries) {
+		t.Errorf("cache holds %d patterns in its list and %d in its map; want at most 4 of each",
+			cache.order.Len(), len(cache.entries))
+	}
+}
+
+func BenchmarkMatchCached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10000; j++ {
+			Match("ERROR: request failed with status 500", `status 5\d\d`)
+		}
+	}
+}
+
+func BenchmarkMatchUncached(b *testing.B) {
+	SetMatchCacheSize(0)
+	defer SetMatchCacheSize(DefaultMatchCacheSize)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10000; j++ {
+			Match("ERROR: request failed with status 500", `status 5\d\d`)
+		}
+	}
+}
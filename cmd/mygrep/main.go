@@ -2,9 +2,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	re "github.com/miy4/mygrep-go"
 )
@@ -15,65 +22,533 @@ const (
 	EXIT_ERROR     = 2
 )
 
+const usage = "Usage: mygrep [OPTION]... PATTERN [FILE]..."
+
+// stdinName labels the lines read from the standard input when filenames are shown.
+const stdinName = "(standard input)"
+
+// defaultGroupSeparator is the line printed between groups of context lines unless --group-separator says otherwise.
+const defaultGroupSeparator = "--"
+
+// cacheSize is the number of distinct lines whose match results --cache remembers.
+const cacheSize = 1024
+
+// The values of --binary-files, which say how to search a file that looks binary because it contains NUL bytes.
+const (
+	binaryFilesBinary       = "binary"        // print a line saying that the file matches instead of its lines
+	binaryFilesText         = "text"          // search the file like any other
+	binaryFilesWithoutMatch = "without-match" // skip the file, as if nothing matched
+)
+
 // cli represents the command line interface.
 type cli struct {
 	in  io.Reader
 	out io.Writer
 	err io.Writer
+
+	binary              bool
+	binaryFiles         string
+	cache               bool
+	color               bool
+	count               bool
+	countMatches        bool
+	explain             bool
+	filesWithoutMatches bool
+	fixedStrings        bool
+	invert              bool
+	lineBuffered        bool
+	onlyMatching        bool
+	forceFilename       bool
+	noFilename          bool
+	recursive           bool
+	nullData            bool
+	suppressErrors      bool
+	wordRegexp          bool
+	include             []string
+	exclude             []string
+	maxFilesize         int64 // skip files larger than this many bytes in a recursive search; 0 means no limit
+	before              int   // lines of context to print before each matching line
+	after               int   // lines of context to print after each matching line
+	groupSeparator      string
+	pre                 string // written before each match, like --color's escape sequence
+	post                string // written after each match
 }
 
-// run executes the command.
-func (c *cli) run(args []string) int {
-	if len(args) < 1 {
-		fmt.Fprintln(c.err, "Usage: mygrep PATTERN [FILE]")
-		return EXIT_ERROR
+// parseArgs reads the options from args into the cli and returns the remaining positional arguments.
+// Options may appear before or after the positional arguments; everything after "--" is positional.
+// Single-letter options may be combined, as in "-Hh".
+func (c *cli) parseArgs(args []string) ([]string, error) {
+	c.groupSeparator = defaultGroupSeparator
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		} else if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		} else if !strings.HasPrefix(arg, "--") {
+			consumed, err := c.parseShortOptions(arg, args[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			i += consumed
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue && takesValue(name) {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("option requires an argument: %s", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch {
+		case name == "--color" || name == "--colour":
+			if !hasValue {
+				value = "always"
+			}
+			switch value {
+			case "always":
+				c.color = true
+			case "never":
+				c.color = false
+			case "auto":
+				c.color = isTerminal(c.out)
+			default:
+				return nil, fmt.Errorf("invalid argument %q for --color", value)
+			}
+		case name == "--binary-files":
+			switch value {
+			case binaryFilesBinary, binaryFilesText, binaryFilesWithoutMatch:
+				c.binaryFiles = value
+			default:
+				return nil, fmt.Errorf("invalid argument %q for --binary-files", value)
+			}
+		case name == "--include" || name == "--exclude":
+			if _, err := filepath.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q for %s", value, name)
+			}
+			if name == "--include" {
+				c.include = append(c.include, value)
+			} else {
+				c.exclude = append(c.exclude, value)
+			}
+		case name == "--after-context" || name == "--before-context" || name == "--context":
+			lines, err := parseContextLength(value)
+			if err != nil {
+				return nil, err
+			}
+			if name != "--before-context" {
+				c.after = lines
+			}
+			if name != "--after-context" {
+				c.before = lines
+			}
+		case name == "--group-separator":
+			c.groupSeparator = value
+		case name == "--pre":
+			c.pre = value
+		case name == "--post":
+			c.post = value
+		case name == "--max-filesize":
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid argument %q for --max-filesize", value)
+			}
+			c.maxFilesize = size
+		case arg == "--binary":
+			c.binary = true
+		case arg == "--text":
+			c.binaryFiles = binaryFilesText
+		case arg == "--cache":
+			c.cache = true
+		case arg == "--count":
+			c.count = true
+		case arg == "--count-matches":
+			c.countMatches = true
+		case arg == "--explain":
+			c.explain = true
+		case arg == "--files-without-match":
+			c.filesWithoutMatches = true
+		case arg == "--fixed-strings":
+			c.fixedStrings = true
+		case arg == "--invert-match":
+			c.invert = true
+		case arg == "--line-buffered":
+			c.lineBuffered = true
+		case arg == "--only-matching":
+			c.onlyMatching = true
+		case arg == "--recursive":
+			c.recursive = true
+		case arg == "--null-data":
+			c.nullData = true
+		case arg == "--with-filename":
+			c.forceFilename, c.noFilename = true, false
+		case arg == "--no-filename":
+			c.forceFilename, c.noFilename = false, true
+		case arg == "--no-messages":
+			c.suppressErrors = true
+		case arg == "--word-regexp":
+			c.wordRegexp = true
+		default:
+			return nil, fmt.Errorf("unknown option: %s", arg)
+		}
+	}
+	return positional, nil
+}
+
+// takesValue reports whether the long option name takes a value, which may follow it as the next argument
+// instead of after '='.
+func takesValue(name string) bool {
+	switch name {
+	case "--include", "--exclude", "--max-filesize", "--after-context", "--before-context", "--context",
+		"--group-separator", "--pre", "--post":
+		return true
 	}
+	return false
+}
 
-	pattern := args[0]
-	containsMatch := false
-	scanner := bufio.NewScanner(c.in)
-	for {
-		if !scanner.Scan() {
-			if err := scanner.Err(); err != nil {
-				fmt.Fprintf(c.err, "Failed to read input: %v\n", err)
-				return EXIT_ERROR
+// parseShortOptions reads a group of single-letter options like "-H" into the cli. An option that takes a number
+// of lines, like -A, takes the rest of the group, as in "-A2", or else the next argument, as in "-A 2".
+// It returns the number of arguments from rest that it consumed.
+func (c *cli) parseShortOptions(arg string, rest []string) (int, error) {
+	for i, option := range arg[1:] {
+		if option == 'A' || option == 'B' || option == 'C' {
+			value, consumed := arg[2+i:], 0
+			if value == "" {
+				if len(rest) == 0 {
+					return 0, fmt.Errorf("option requires an argument: -%c", option)
+				}
+				value, consumed = rest[0], 1
 			}
-			break
+			lines, err := parseContextLength(value)
+			if err != nil {
+				return 0, err
+			}
+			if option != 'B' {
+				c.after = lines
+			}
+			if option != 'A' {
+				c.before = lines
+			}
+			return consumed, nil
+		}
+
+		switch option {
+		case 'a':
+			c.binaryFiles = binaryFilesText
+		case 'U':
+			c.binary = true
+		case 'c':
+			c.count = true
+		case 'F':
+			c.fixedStrings = true
+		case 'L':
+			c.filesWithoutMatches = true
+		case 'H':
+			c.forceFilename, c.noFilename = true, false
+		case 'h':
+			c.forceFilename, c.noFilename = false, true
+		case 'o':
+			c.onlyMatching = true
+		case 'r':
+			c.recursive = true
+		case 's':
+			c.suppressErrors = true
+		case 'v':
+			c.invert = true
+		case 'w':
+			c.wordRegexp = true
+		case 'z':
+			c.nullData = true
+		default:
+			return 0, fmt.Errorf("unknown option: -%c", option)
 		}
+	}
+	return 0, nil
+}
+
+// parseContextLength parses the number of lines of context given to -A, -B or -C.
+func parseContextLength(value string) (int, error) {
+	lines, err := strconv.Atoi(value)
+	if err != nil || lines < 0 {
+		return 0, fmt.Errorf("invalid context length: %s", value)
+	}
+	return lines, nil
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
 
-		line := scanner.Text()
-		ok, err := re.Match(line, pattern)
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run executes the command and returns its exit status, as grep does: EXIT_ERROR if the arguments are invalid
+// or any file could not be searched, otherwise EXIT_OK if any line matched in any file and EXIT_NOT_MATCH if none did.
+func (c *cli) run(args []string) int {
+	positional, err := c.parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(c.err, "%v\n%s\n", err, usage)
+		return EXIT_ERROR
+	} else if len(positional) < 1 {
+		fmt.Fprintln(c.err, usage)
+		return EXIT_ERROR
+	}
+
+	pattern := positional[0]
+	if c.fixedStrings {
+		pattern = re.QuoteMeta(pattern)
+	}
+	if c.explain {
+		return c.explainPattern(pattern)
+	}
+
+	regexp, err := re.Compile(pattern)
+	if err != nil {
+		fmt.Fprintf(c.err, "Failed to match: %v\n", err)
+		return EXIT_ERROR
+	}
+
+	files := positional[1:]
+	if len(files) == 0 && c.recursive {
+		files = []string{"."}
+	} else if len(files) == 0 {
+		files = []string{"-"}
+	}
+	showFilename := (len(files) > 1 || c.recursive && isDir(files[0]) || c.forceFilename) && !c.noFilename
+
+	// Output is buffered, unless --line-buffered asks for every line to be written as soon as it is found.
+	out, flush := c.out, func() error { return nil }
+	if !c.lineBuffered {
+		buffered := bufio.NewWriter(c.out)
+		out, flush = buffered, buffered.Flush
+	}
+
+	// With -s, a file that cannot be read is skipped silently and does not make the search fail.
+	containsMatch, failed := false, false
+	fail := func(err error) {
+		if !c.suppressErrors {
+			flush()
+			fmt.Fprintln(c.err, err)
+			failed = true
+		}
+	}
+	search := func(name string) {
+		matched, err := c.grepFile(out, regexp, name, showFilename)
 		if err != nil {
-			fmt.Fprintf(c.err, "Failed to match: %v\n", err)
-			return EXIT_ERROR
-		} else if ok {
-			containsMatch = true
-			fmt.Fprintln(c.out, line)
+			fail(err)
 		}
+		containsMatch = containsMatch || matched
 	}
 
-	if !containsMatch {
+	for _, name := range files {
+		if !c.recursive || !isDir(name) {
+			search(name)
+			continue
+		}
+
+		filepath.WalkDir(name, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fail(fmt.Errorf("%s: Failed to read directory: %v", path, err))
+			} else if !d.IsDir() && c.selected(d.Name()) && !c.tooLarge(d) {
+				search(path)
+			}
+			return nil
+		})
+	}
+
+	if err := flush(); err != nil {
+		fmt.Fprintf(c.err, "Failed to write output: %v\n", err)
+		failed = true
+	}
+
+	if failed {
+		return EXIT_ERROR
+	} else if !containsMatch {
 		return EXIT_NOT_MATCH
 	}
 	return EXIT_OK
 }
 
-// main is the entry point of the command.
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s PATTERN [FILE]\n", os.Args[0])
-		os.Exit(EXIT_ERROR)
+// explainPattern prints a description of the pattern for --explain, without searching any files.
+func (c *cli) explainPattern(pattern string) int {
+	tokens, err := re.Parse(pattern)
+	if err != nil {
+		fmt.Fprintf(c.err, "Failed to match: %v\n", err)
+		return EXIT_ERROR
 	}
+	fmt.Fprintln(c.out, re.Describe(tokens))
+	return EXIT_OK
+}
 
-	cli := &cli{in: os.Stdin, out: os.Stdout, err: os.Stderr}
-	if len(os.Args) > 2 {
-		var err error
-		cli.in, err = os.Open(os.Args[2])
+// isDir reports whether name is a directory.
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// selected reports whether a file found in a recursive search should be searched, judging by its base name:
+// it must match one of the --include globs, if there are any, and none of the --exclude globs.
+func (c *cli) selected(base string) bool {
+	for _, glob := range c.exclude {
+		if matched, _ := filepath.Match(glob, base); matched {
+			return false
+		}
+	}
+
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, glob := range c.include {
+		if matched, _ := filepath.Match(glob, base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLarge reports whether a file found in a recursive search is larger than --max-filesize allows.
+// A file whose size cannot be read is not too large, so that searching it reports the error.
+func (c *cli) tooLarge(d fs.DirEntry) bool {
+	if c.maxFilesize == 0 {
+		return false
+	}
+	info, err := d.Info()
+	return err == nil && info.Size() > c.maxFilesize
+}
+
+// sizeSuffixes are the multipliers of the suffixes that a size given to --max-filesize may have.
+var sizeSuffixes = map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}
+
+// parseSize parses a number of bytes like "512", "64K", "1M" or "2G", where the suffixes, in either case,
+// stand for powers of 1024.
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	if value != "" {
+		if m, ok := sizeSuffixes[strings.ToUpper(value[len(value)-1:])[0]]; ok {
+			value, multiplier = value[:len(value)-1], m
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return size * multiplier, nil
+}
+
+// grepFile searches the named file, decompressing it if it is gzip-compressed, or the standard input if the name
+// is "-", and prints the matching lines.
+// The output goes to w. If showFilename is set, each line is prefixed with the name of the file it was found in.
+// A file that looks binary is handled as --binary-files says; by default, a single line says whether it matches.
+// It reports whether any line matched, or with -L, whether the file had no match and its name was printed.
+func (c *cli) grepFile(w io.Writer, regexp *re.Regexp, name string, showFilename bool) (bool, error) {
+	in, label := c.in, stdinName
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return false, fmt.Errorf("%s: Failed to open file: %v", name, err)
+		}
+		defer file.Close()
+		in, label = file, name
+
+		decompressed, err := decompress(file, name)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: Failed to open file: %v\n", os.Args[2], err)
-			os.Exit(EXIT_ERROR)
+			return false, fmt.Errorf("%s: Failed to decompress file: %v", name, err)
 		}
+		in = decompressed
+	}
+
+	buffered := bufio.NewReader(in)
+	binary := c.binaryFiles != binaryFilesText && !c.nullData && isBinary(buffered)
+	if binary && c.binaryFiles == binaryFilesWithoutMatch {
+		return false, nil
 	}
 
+	opts := re.GrepOptions{
+		Color:        c.color,
+		Pre:          c.pre,
+		Post:         c.post,
+		Invert:       c.invert,
+		Count:        c.count,
+		CountMatches: c.countMatches,
+		OnlyMatching: c.onlyMatching,
+		WordRegexp:   c.wordRegexp,
+		NullData:     c.nullData,
+		KeepCR:       c.binary,
+
+		Before:         c.before,
+		After:          c.after,
+		GroupSeparator: c.groupSeparator,
+	}
+	if showFilename {
+		opts.Label = label
+	}
+	if c.cache {
+		opts.CacheSize = cacheSize
+	}
+
+	// The lines of a binary file would print as garbage, so only the first match is looked for, to report it.
+	// Counts are printed as they are. With -L, the first match is enough to leave the file out.
+	summarize := binary && !c.count && !c.countMatches
+	out := w
+	if summarize || c.filesWithoutMatches {
+		out, opts.MaxCount = io.Discard, 1
+	}
+
+	count, err := re.Grep(buffered, out, regexp, opts)
+	if err != nil {
+		return count > 0, fmt.Errorf("Failed to read input: %v", err)
+	}
+	if c.filesWithoutMatches {
+		if count > 0 {
+			return false, nil
+		} else if _, err := fmt.Fprintln(w, label); err != nil {
+			return true, fmt.Errorf("Failed to write output: %v", err)
+		}
+		return true, nil
+	}
+	if summarize && count > 0 {
+		if _, err := fmt.Fprintf(w, "Binary file %s matches\n", label); err != nil {
+			return true, fmt.Errorf("Failed to write output: %v", err)
+		}
+	}
+	return count > 0, nil
+}
+
+// isBinary reports whether the first chunk of data that r reads contains a NUL byte, which text never does.
+// It only looks at what a single read returns, so it does not wait for more input from a pipe.
+func isBinary(r *bufio.Reader) bool {
+	r.Peek(1)
+	chunk, _ := r.Peek(r.Buffered())
+	return bytes.IndexByte(chunk, 0) >= 0
+}
+
+// gzipMagic is the header that every gzip-compressed file starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader for the contents of the named file, which r reads. A file that has a ".gz" extension
+// or starts with the gzip header is decompressed; any other file is read as it is.
+func decompress(r io.Reader, name string) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !strings.HasSuffix(name, ".gz") && !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+	return gzip.NewReader(buffered)
+}
+
+// main is the entry point of the command.
+func main() {
+	cli := &cli{in: os.Stdin, out: os.Stdout, err: os.Stderr}
 	os.Exit(cli.run(os.Args[1:]))
 }
@@ -1,25 +1,30 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 func TestRun(t *testing.T) {
 	tests := []struct {
-		name string
-		args []string
-		in   string
-		out  string
-		err  string
-		want int
+		name  string
+		args  []string
+		files map[string]string
+		in    string
+		out   string
+		err   string
+		want  int
 	}{
 		{
 			name: "no args",
 			args: []string{},
 			in:   "",
 			out:  "",
-			err:  "Usage: mygrep PATTERN [FILE]\n",
+			err:  "Usage: mygrep [OPTION]... PATTERN [FILE]...\n",
 			want: EXIT_ERROR,
 		},
 		{
@@ -30,9 +35,683 @@ func TestRun(t *testing.T) {
 			err:  "",
 			want: EXIT_OK,
 		},
+		{
+			name: "color",
+			args: []string{"--color", "\\d+"},
+			in:   "a12b3\nxyz\n",
+			out:  "a\x1b[01;31m12\x1b[mb\x1b[01;31m3\x1b[m\n",
+			err:  "",
+			want: EXIT_OK,
+		},
+		{
+			name: "color always after pattern",
+			args: []string{"b", "--color=always"},
+			in:   "abc\n",
+			out:  "a\x1b[01;31mb\x1b[mc\n",
+			err:  "",
+			want: EXIT_OK,
+		},
+		{
+			name: "color never",
+			args: []string{"--color=never", "b"},
+			in:   "abc\n",
+			out:  "abc\n",
+			err:  "",
+			want: EXIT_OK,
+		},
+		{
+			name: "color skips empty matches",
+			args: []string{"--color", "x*"},
+			in:   "abc\n",
+			out:  "abc\n",
+			err:  "",
+			want: EXIT_OK,
+		},
+		{
+			name: "invalid color",
+			args: []string{"--color=sometimes", "a"},
+			in:   "a\n",
+			out:  "",
+			err:  "invalid argument \"sometimes\" for --color\nUsage: mygrep [OPTION]... PATTERN [FILE]...\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "unknown option",
+			args: []string{"--frobnicate", "a"},
+			in:   "a\n",
+			out:  "",
+			err:  "unknown option: --frobnicate\nUsage: mygrep [OPTION]... PATTERN [FILE]...\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name:  "file",
+			args:  []string{"a", "one.txt"},
+			files: map[string]string{"one.txt": "a1\nb1\n"},
+			out:   "a1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "count",
+			args: []string{"-c", "an"},
+			in:   "banana\ncherry\nmango\n",
+			out:  "2\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "count matches",
+			args: []string{"--count-matches", "an"},
+			in:   "banana\ncherry\nmango\n",
+			out:  "3\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "count matches on one line",
+			args: []string{"an", "--count-matches"},
+			in:   "banana\n",
+			out:  "2\n",
+			want: EXIT_OK,
+		},
+		{
+			name:  "count without match",
+			args:  []string{"--count", "x", "one.txt", "two.txt"},
+			files: map[string]string{"one.txt": "a1\n", "two.txt": "x2\n"},
+			out:   "one.txt:0\ntwo.txt:1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "explain",
+			args: []string{"--explain", "a\\d+$", "missing.txt"},
+			out:  "literal 'a', one or more of digit, end of string\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "explain invalid pattern",
+			args: []string{"--explain", "a("},
+			err:  "Failed to match: missing closing ')' for '(' at position 1 in \"a(\"\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "cache",
+			args: []string{"--cache", "-c", "\\bb"},
+			in:   "a b\nab\na b\nab\nb\n",
+			out:  "3\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "fixed strings",
+			args: []string{"-F", "a.b"},
+			in:   "axb\na.b\n",
+			out:  "a.b\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "fixed strings with metacharacters",
+			args: []string{"--fixed-strings", "-o", "(a|b)*\\d"},
+			in:   "ab1\nx(a|b)*\\dy\n",
+			out:  "(a|b)*\\d\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "fixed strings explained",
+			args: []string{"-F", "--explain", "a.$"},
+			out:  "literal 'a', literal '.', literal '$'\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "word regexp",
+			args: []string{"-w", "cat"},
+			in:   "a cat.\nscatter\ncat\n",
+			out:  "a cat.\ncat\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "word regexp no match",
+			args: []string{"--word-regexp", "cat"},
+			in:   "scatter\ncats\n",
+			out:  "",
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "only matching",
+			args: []string{"-o", "\\d+"},
+			in:   "a12b3\nxyz\n4\n",
+			out:  "12\n3\n4\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "only matching with color",
+			args: []string{"--only-matching", "--color", "\\d+"},
+			in:   "a12b3\nxyz\n",
+			out:  "\x1b[01;31m12\x1b[m\n\x1b[01;31m3\x1b[m\n",
+			want: EXIT_OK,
+		},
+		{
+			name:  "only matching with filenames",
+			args:  []string{"-oH", "b.", "one.txt"},
+			files: map[string]string{"one.txt": "abcabd\n"},
+			out:   "one.txt:bc\none.txt:bd\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "line longer than the scanner default",
+			args: []string{"needle"},
+			in:   strings.Repeat("x", 1<<20) + "needle\nhay\n",
+			out:  strings.Repeat("x", 1<<20) + "needle\n",
+			want: EXIT_OK,
+		},
+		{
+			name:  "multiple files",
+			args:  []string{"a", "one.txt", "two.txt"},
+			files: map[string]string{"one.txt": "a1\nb1\n", "two.txt": "b2\na2\n"},
+			out:   "one.txt:a1\ntwo.txt:a2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "multiple files with stdin",
+			args:  []string{"a", "-", "one.txt"},
+			files: map[string]string{"one.txt": "a1\n"},
+			in:    "a0\n",
+			out:   "(standard input):a0\none.txt:a1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "force filename for a single file",
+			args:  []string{"-H", "a", "one.txt"},
+			files: map[string]string{"one.txt": "a1\nb1\n"},
+			out:   "one.txt:a1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "force filename for stdin",
+			args: []string{"a", "--with-filename"},
+			in:   "a0\n",
+			out:  "(standard input):a0\n",
+			want: EXIT_OK,
+		},
+		{
+			name:  "suppress filename for multiple files",
+			args:  []string{"-h", "a", "one.txt", "two.txt"},
+			files: map[string]string{"one.txt": "a1\n", "two.txt": "a2\n"},
+			out:   "a1\na2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "last filename option wins",
+			args:  []string{"-hH", "a", "one.txt"},
+			files: map[string]string{"one.txt": "a1\n"},
+			out:   "one.txt:a1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "no match in multiple files",
+			args:  []string{"x", "one.txt", "two.txt"},
+			files: map[string]string{"one.txt": "a1\n", "two.txt": "a2\n"},
+			want:  EXIT_NOT_MATCH,
+		},
+		{
+			name:  "match in one of multiple files",
+			args:  []string{"2", "one.txt", "two.txt"},
+			files: map[string]string{"one.txt": "a1\n", "two.txt": "a2\n"},
+			out:   "two.txt:a2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "missing file without match",
+			args:  []string{"x", "one.txt", "missing.txt"},
+			files: map[string]string{"one.txt": "a1\n"},
+			err:   "missing.txt: Failed to open file: open missing.txt: no such file or directory\n",
+			want:  EXIT_ERROR,
+		},
+		{
+			name:  "missing file among others",
+			args:  []string{"a", "missing.txt", "one.txt"},
+			files: map[string]string{"one.txt": "a1\n"},
+			out:   "one.txt:a1\n",
+			err:   "missing.txt: Failed to open file: open missing.txt: no such file or directory\n",
+			want:  EXIT_ERROR,
+		},
+		{
+			name:  "no messages for a missing file",
+			args:  []string{"-s", "a", "missing.txt", "one.txt"},
+			files: map[string]string{"one.txt": "a1\n"},
+			out:   "one.txt:a1\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "no messages for an unreadable file",
+			args:  []string{"a", "dir/sub", "one.txt", "--no-messages"},
+			files: map[string]string{"one.txt": "b1\n", "dir/sub/two.txt": "a2\n"},
+			want:  EXIT_NOT_MATCH,
+		},
+		{
+			name:  "unreadable file",
+			args:  []string{"a", "dir/sub", "one.txt"},
+			files: map[string]string{"one.txt": "a1\n", "dir/sub/two.txt": "a2\n"},
+			out:   "one.txt:a1\n",
+			err:   "Failed to read input: read dir/sub: is a directory\n",
+			want:  EXIT_ERROR,
+		},
+		{
+			name: "no messages does not hide an invalid pattern",
+			args: []string{"-s", "[a", "one.txt"},
+			err:  "Failed to match: unclosed '[' in positive set\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name:  "gzip file",
+			args:  []string{"a", "log.gz"},
+			files: map[string]string{"log.gz": gzipped("a1\nb1\na2\n")},
+			out:   "a1\na2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "gzip file without extension",
+			args:  []string{"b", "log", "plain.txt"},
+			files: map[string]string{"log": gzipped("a1\nb1\n"), "plain.txt": "b2\n"},
+			out:   "log:b1\nplain.txt:b2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "invalid gzip file",
+			args:  []string{"a", "log.gz"},
+			files: map[string]string{"log.gz": "a1 is not compressed\n"},
+			err:   "log.gz: Failed to decompress file: gzip: invalid header\n",
+			want:  EXIT_ERROR,
+		},
+		{
+			name:  "truncated gzip file",
+			args:  []string{"a", "log.gz"},
+			files: map[string]string{"log.gz": gzipped("a1\nb1\n")[:20]},
+			out:   "a1\n",
+			err:   "Failed to read input: unexpected EOF\n",
+			want:  EXIT_ERROR,
+		},
+		{
+			name: "invert match",
+			args: []string{"--invert-match", "a"},
+			in:   "a\nb\nc\n",
+			out:  "b\nc\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "invert count",
+			args: []string{"a", "-v", "-c"},
+			in:   "a\nb\nc\n",
+			out:  "2\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "invert count without selected lines",
+			args: []string{"-vc", "."},
+			in:   "a\nb\n",
+			out:  "0\n",
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "files without match",
+			args: []string{"-L", "match", "one.txt", "two.txt"},
+			files: map[string]string{
+				"one.txt": "a\nmatch\nb\n",
+				"two.txt": "a\nb\n",
+			},
+			out:  "two.txt\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "files without match when every file matches",
+			args: []string{"--files-without-match", "a", "one.txt", "two.txt"},
+			files: map[string]string{
+				"one.txt": "a\n",
+				"two.txt": "ba\n",
+			},
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "files without match recursive",
+			args: []string{"-rL", "match", "src"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/util/util.go": "util\n",
+				"src/notes.txt":    "notes\n",
+			},
+			out:  "src/notes.txt\nsrc/util/util.go\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "files without match on the standard input",
+			args: []string{"-L", "x"},
+			in:   "a\n",
+			out:  "(standard input)\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "after context",
+			args: []string{"-A", "1", "x"},
+			in:   "x1\na\nb\nx2\nc\n",
+			out:  "x1\na\n--\nx2\nc\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "before context",
+			args: []string{"-cB1", "x"},
+			in:   "a\nb\nx1\nc\nd\nx2\n",
+			out:  "2\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "before context in a group of options",
+			args: []string{"-HB1", "x", "-"},
+			in:   "a\nb\nx1\nc\nd\nx2\n",
+			out:  "(standard input)-b\n(standard input):x1\n--\n(standard input)-d\n(standard input):x2\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "group separator",
+			args: []string{"-C", "1", "--group-separator=***", "x"},
+			in:   "x1\na\nb\nc\nx2\n",
+			out:  "x1\na\n***\nc\nx2\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "empty group separator",
+			args: []string{"--context=1", "--group-separator", "", "x"},
+			in:   "x1\na\nb\nc\nx2\n",
+			out:  "x1\na\nc\nx2\n",
+			want: EXIT_OK,
+		},
+		{
+			name:  "context with filenames",
+			args:  []string{"-H", "--after-context", "1", "x", "one.txt"},
+			files: map[string]string{"one.txt": "x\na\n"},
+			out:   "one.txt:x\none.txt-a\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "invalid context length",
+			args: []string{"-A", "many", "x"},
+			err:  "invalid context length: many\n" + usage + "\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "missing context length",
+			args: []string{"x", "-C"},
+			err:  "option requires an argument: -C\n" + usage + "\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "pre and post",
+			args: []string{"\\d", "--pre=[", "--post=]"},
+			in:   "a1b\nc\n",
+			out:  "a[1]b\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "pre only matching",
+			args: []string{"-o", "--pre", "<<", "--post", ">>", "\\d+"},
+			in:   "a12b3\n",
+			out:  "<<12>>\n<<3>>\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "color overrides pre and post",
+			args: []string{"--color=always", "--pre=[", "--post=]", "1"},
+			in:   "a1b\n",
+			out:  "a\x1b[01;31m1\x1b[mb\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "unknown short option",
+			args: []string{"-Hy", "a"},
+			err:  "unknown option: -y\nUsage: mygrep [OPTION]... PATTERN [FILE]...\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "recursive",
+			args: []string{"-r", "match", "src"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "src/main.go:match go\nsrc/main_test.go:match test\nsrc/notes.txt:match txt\nsrc/util/util.go:match util\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "recursive from the working directory",
+			args: []string{"--recursive", "match", "--include=*.md"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "README.md:match md\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "recursive single file",
+			args: []string{"-r", "match", "README.md"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "match md\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "max filesize",
+			args: []string{"-r", "--max-filesize", "1K", "match", "src"},
+			files: map[string]string{
+				"src/small.js": "match small\n",
+				"src/large.js": "match large\n" + strings.Repeat("x", 2048) + "\n",
+			},
+			out:  "src/small.js:match small\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "max filesize only applies to recursive searches",
+			args: []string{"--max-filesize=10", "match", "large.js"},
+			files: map[string]string{
+				"large.js": "match large\n" + strings.Repeat("x", 20) + "\n",
+			},
+			out:  "match large\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "invalid max filesize",
+			args: []string{"-r", "--max-filesize=1X", "match"},
+			err:  "invalid argument \"1X\" for --max-filesize\n" + usage + "\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "include",
+			args: []string{"-r", "--include", "*.go", "match", "src"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "src/main.go:match go\nsrc/main_test.go:match test\nsrc/util/util.go:match util\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "include and exclude",
+			args: []string{"-r", "--include=*.go", "--exclude=*_test.go", "match", "src"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "src/main.go:match go\nsrc/util/util.go:match util\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "includes accumulate",
+			args: []string{"-r", "--include=*.txt", "--include=util.*", "match", "src"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "src/notes.txt:match txt\nsrc/util/util.go:match util\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "excludes accumulate",
+			args: []string{"-r", "--exclude=*.go", "--exclude", "*.md", "match"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			out:  "src/notes.txt:match txt\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "exclude everything",
+			args: []string{"-r", "--exclude=*", "match"},
+			files: map[string]string{
+				"src/main.go":      "match go\n",
+				"src/main_test.go": "match test\n",
+				"src/util/util.go": "match util\n",
+				"src/notes.txt":    "match txt\n",
+				"README.md":        "match md\n",
+			},
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "invalid glob",
+			args: []string{"-r", "--include=[", "a"},
+			err:  "invalid glob \"[\" for --include\nUsage: mygrep [OPTION]... PATTERN [FILE]...\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "missing glob",
+			args: []string{"a", "--exclude"},
+			err:  "option requires an argument: --exclude\nUsage: mygrep [OPTION]... PATTERN [FILE]...\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "null data",
+			args: []string{"a", "-z"},
+			in:   "a\x00b\x00",
+			out:  "a\x00",
+			want: EXIT_OK,
+		},
+		{
+			name: "null data keeps newlines in records",
+			args: []string{"--null-data", "b$"},
+			in:   "a\nb\x00c\nd\x00b\nc",
+			out:  "a\nb\x00",
+			want: EXIT_OK,
+		},
+		{
+			name:  "binary file",
+			args:  []string{"b", "bin", "text.txt"},
+			files: map[string]string{"bin": "a\x00\nb\x00\nb\n", "text.txt": "b\n"},
+			out:   "Binary file bin matches\ntext.txt:b\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "binary standard input without a match",
+			args: []string{"x"},
+			in:   "a\x00b\n",
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name:  "binary file as text",
+			args:  []string{"-a", "b", "bin"},
+			files: map[string]string{"bin": "a\x00\nb\x00\n"},
+			out:   "b\x00\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "binary file without match",
+			args:  []string{"--binary-files=without-match", "b", "bin", "text.txt"},
+			files: map[string]string{"bin": "b\x00\n", "text.txt": "b\n"},
+			out:   "text.txt:b\n",
+			want:  EXIT_OK,
+		},
+		{
+			name:  "binary file count",
+			args:  []string{"-c", "b", "bin"},
+			files: map[string]string{"bin": "b\x00\nb\n"},
+			out:   "2\n",
+			want:  EXIT_OK,
+		},
+		{
+			name: "invalid binary files",
+			args: []string{"--binary-files=maybe", "b"},
+			err:  "invalid argument \"maybe\" for --binary-files\n" + usage + "\n",
+			want: EXIT_ERROR,
+		},
+		{
+			name: "carriage returns",
+			args: []string{"a$"},
+			in:   "a\r\nb\r\n",
+			out:  "a\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "binary keeps carriage returns",
+			args: []string{"-U", "a.$"},
+			in:   "a\r\nb\r\n",
+			out:  "a\r\n",
+			want: EXIT_OK,
+		},
+		{
+			name: "null data dot does not span newlines",
+			args: []string{"-z", "a.b"},
+			in:   "a\nb\x00",
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "null data only matching dot does not span newlines",
+			args: []string{"-zo", "a.b"},
+			in:   "a\nb\x00",
+			want: EXIT_NOT_MATCH,
+		},
+		{
+			name: "null data dot spans newlines with the s flag",
+			args: []string{"-z", "(?s)a.b"},
+			in:   "a\nb\x00",
+			out:  "a\nb\x00",
+			want: EXIT_OK,
+		},
+		{
+			name:  "null data with filenames",
+			args:  []string{"-zH", "x", "one.txt"},
+			files: map[string]string{"one.txt": "./x\x00./y\x00"},
+			out:   "one.txt:./x\x00",
+			want:  EXIT_OK,
+		},
 	}
 
 	for _, tt := range tests {
+		chdir(t, t.TempDir())
+		for name, content := range tt.files {
+			if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
 		outBuffer := &strings.Builder{}
 		errBuffer := &strings.Builder{}
 		cli := &cli{
@@ -50,3 +729,88 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"64K", 64 << 10, false},
+		{"1m", 1 << 20, false},
+		{"2G", 2 << 30, false},
+		{"", 0, true},
+		{"K", 0, true},
+		{"-1", 0, true},
+		{"1.5M", 0, true},
+		{"1T", 0, true},
+		{"9223372036854775807G", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v; wantErr %v", tt.value, err, tt.wantErr)
+		} else if got != tt.want {
+			t.Errorf("parseSize(%q) = %d; want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+// writeCounter collects what is written to it and counts the calls to Write.
+type writeCounter struct {
+	written strings.Builder
+	writes  int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.written.Write(p)
+}
+
+func (w *writeCounter) String() string { return w.written.String() }
+
+func TestRunLineBuffered(t *testing.T) {
+	tests := []struct {
+		args   []string
+		writes int
+	}{
+		{[]string{"a"}, 1},
+		{[]string{"--line-buffered", "a"}, 3},
+	}
+
+	for _, tt := range tests {
+		out := &writeCounter{}
+		cli := &cli{in: strings.NewReader("a1\nb\na2\na3\n"), out: out, err: &strings.Builder{}}
+		if exit := cli.run(tt.args); exit != EXIT_OK {
+			t.Fatalf("run(%q) = %d; want %d", tt.args, exit, EXIT_OK)
+		}
+		if out.String() != "a1\na2\na3\n" || out.writes != tt.writes {
+			t.Errorf("run(%q) wrote %q in %d writes; want %q in %d", tt.args, out.String(), out.writes, "a1\na2\na3\n", tt.writes)
+		}
+	}
+}
+
+// gzipped returns s compressed with gzip.
+func gzipped(s string) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.String()
+}
+
+// chdir changes the working directory to dir until the test ends.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
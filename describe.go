@@ -0,0 +1,131 @@
+package re
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describe returns a description of the tokens of a pattern, as returned by Parse, in plain English, like
+// "literal 'a', one or more of digit, end of string" for "a\d+$". The tokens of a sequence are separated by
+// commas, and the contents of groups are described in parentheses.
+func Describe(tokens []Token) string {
+	if len(tokens) == 0 {
+		return "empty string"
+	}
+
+	descriptions := make([]string, len(tokens))
+	for i, token := range tokens {
+		descriptions[i] = describeToken(token)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// describeToken returns the description of a single token for Describe.
+func describeToken(token Token) string {
+	switch t := token.(type) {
+	case literalToken:
+		return fmt.Sprintf("literal %q", t.char)
+	case digitToken:
+		return "digit" + anyScript(t.unicode)
+	case wordToken:
+		return "word character" + anyScript(t.unicode)
+	case propertyToken:
+		return "character matching " + t.String()
+	case positiveSetToken:
+		return "one of " + t.String()
+	case negativeSetToken:
+		return "none of " + positiveSetToken{ranges: t.ranges}.String()
+	case beginningOfStringToken:
+		if t.multiline {
+			return "beginning of line"
+		}
+		return "beginning of string"
+	case endOfStringToken:
+		if t.multiline {
+			return "end of line"
+		}
+		return "end of string"
+	case wildcardToken:
+		if t.dotAll {
+			return "any character"
+		}
+		return "any character except newline"
+	case plusToken:
+		return lazyPrefix(t.lazy) + "one or more of " + describeToken(t.payload)
+	case starToken:
+		return lazyPrefix(t.lazy) + "zero or more of " + describeToken(t.payload)
+	case optionalToken:
+		return lazyPrefix(t.lazy) + "optional " + describeToken(t.payload)
+	case repeatToken:
+		var count string
+		switch {
+		case t.max == -1:
+			count = fmt.Sprintf("at least %d of ", t.min)
+		case t.max == t.min:
+			count = fmt.Sprintf("exactly %d of ", t.min)
+		default:
+			count = fmt.Sprintf("between %d and %d of ", t.min, t.max)
+		}
+		return lazyPrefix(t.lazy) + count + describeToken(t.payload)
+	case possessiveToken:
+		return "possessive " + describeToken(t.payload)
+	case groupToken:
+		if t.capturing && t.name != "" {
+			return fmt.Sprintf("group %d %q (%s)", t.index, t.name, describeAlternatives(t.payload))
+		} else if t.capturing {
+			return fmt.Sprintf("group %d (%s)", t.index, describeAlternatives(t.payload))
+		}
+		return "(" + describeAlternatives(t.payload) + ")"
+	case atomicGroupToken:
+		return "atomic group (" + describeAlternatives(t.payload) + ")"
+	case lookaheadToken:
+		return negatedPrefix(t.negated) + "followed by (" + describeAlternatives(t.payload) + ")"
+	case lookbehindToken:
+		return negatedPrefix(t.negated) + "preceded by (" + describeAlternatives(t.payload) + ")"
+	case conditionalToken:
+		branches := t.branches()
+		return fmt.Sprintf("if group %d matched (%s) else (%s)", t.group, Describe(branches[0]), Describe(branches[1]))
+	case backreferenceToken:
+		return fmt.Sprintf("same text as group %d", t.group)
+	}
+	// The tokens without fields are described by the name of their kind: anchors and word boundaries.
+	return token.Op().String()
+}
+
+// describeAlternatives returns the description of the alternatives of a group: the sequence of a single
+// alternative, or each sequence in parentheses, joined by "or".
+func describeAlternatives(payload [][]Token) string {
+	if len(payload) == 1 {
+		return Describe(payload[0])
+	}
+
+	alternatives := make([]string, len(payload))
+	for i, tokens := range payload {
+		alternatives[i] = "(" + Describe(tokens) + ")"
+	}
+	return "either " + strings.Join(alternatives, " or ")
+}
+
+// anyScript returns the qualifier of a class that covers every script in Unicode mode.
+func anyScript(unicode bool) string {
+	if unicode {
+		return " of any script"
+	}
+	return ""
+}
+
+// lazyPrefix returns the qualifier of a lazy quantifier.
+func lazyPrefix(lazy bool) string {
+	if lazy {
+		return "lazily "
+	}
+	return ""
+}
+
+// negatedPrefix returns the qualifier of a negative lookaround assertion.
+func negatedPrefix(negated bool) string {
+	if negated {
+		return "not "
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package re
+
+import "testing"
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"a\\d+$", "literal 'a', one or more of digit, end of string"},
+		{"", "empty string"},
+		{"^.*?\\w{2,}", "beginning of string, lazily zero or more of any character except newline, at least 2 of word character"},
+		{"(?su)[a-c]?[^x]{3}.\\d", "optional one of [a-c], exactly 3 of none of [x], any character, digit of any script"},
+		{"(a|b)\\1", "group 1 (either (literal 'a') or (literal 'b')), same text as group 1"},
+		{"(?P<x>a)", "group 1 \"x\" (literal 'a')"},
+		{"(?:ab|)+", "one or more of (either (literal 'a', literal 'b') or (empty string))"},
+		{"\\bx{1,2}+\\B", "word boundary, possessive between 1 and 2 of literal 'x', non-word boundary"},
+		{"(?m)^\\Aa(?<!b)(?=c)(?>d)\\z$", "beginning of line, start of input, literal 'a', not preceded by (literal 'b'), " +
+			"followed by (literal 'c'), atomic group (literal 'd'), end of input, end of line"},
+		{"(a)?(?(1)b)", "optional group 1 (literal 'a'), if group 1 matched (literal 'b') else (empty string)"},
+		{"\\p{Greek}", "character matching \\p{Greek}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			tokens, err := Parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.pattern, err)
+			}
+			if got := Describe(tokens); got != tt.expected {
+				t.Errorf("Describe(Parse(%q)) = %q; want %q", tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package re
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDFAStates bounds the number of states a dfa builds. Some patterns, like "(a|b)*a(a|b){20}", need
+// exponentially many, and past the limit the search falls back to the NFA instead of using up memory.
+const maxDFAStates = 10000
+
+// dfa decides whether a string contains a match of an NFA by following one deterministic state per rune.
+// Each dfaState stands for the set of NFA states that the backtracking search could be in at that point,
+// and is built lazily, the first time a rune leads to it, so only the states the inputs need are ever built.
+// Once built, a transition is a table lookup, and scanning an input allocates nothing.
+//
+// A dfa only supports NFAs without assertions, backreferences, conditionals or sub-searches, whose transitions depend
+// on nothing but the next rune; see dfaEligible. It reports whether there is a match, not where.
+// A dfa caches its states and must not be used by several goroutines at once.
+type dfa struct {
+	start  *dfaState
+	states map[string]*dfaState
+}
+
+// dfaState is a state of a dfa: a set of NFA states, closed under epsilon transitions.
+// The transitions on ASCII runes are kept in a table, and those on other runes in a map.
+type dfaState struct {
+	nfaStates []*state
+	isFinal   bool
+	ascii     [utf8.RuneSelf]*dfaState
+	next      map[rune]*dfaState
+}
+
+// dfaEligible reports whether n can be searched by a dfa: none of its states checks an assertion,
+// a backreference or a conditional group, or runs a sub-search, so a match depends on the runes of the input
+// alone. States that record a capturing group are allowed, since a dfa only reports whether there is a match.
+func dfaEligible(n *nfa) bool {
+	eligible := true
+	n.walk(func(st *state) {
+		if st.assert != nil || st.backref > 0 || st.groupSet > 0 || st.groupUnset > 0 || st.sub != nil {
+			eligible = false
+		}
+	})
+	return eligible
+}
+
+// newDFA returns a dfa for n, which must be an NFA made by nfa.unanchored or nfa.anchoredStart, with numbered states.
+func newDFA(n *nfa) *dfa {
+	d := &dfa{states: map[string]*dfaState{}}
+	d.start = d.state([]*state{n.start})
+	return d
+}
+
+// matchString reports whether s contains a match. It reports false as its second result if the dfa
+// has reached maxDFAStates and s needs more states; the caller must then search with the NFA instead.
+func (d *dfa) matchString(s string) (bool, bool) {
+	current := d.start
+	for i := 0; i < len(s); {
+		if current.isFinal {
+			return true, true
+		} else if len(current.nfaStates) == 0 {
+			// Only an anchored NFA runs out of states, and then no match can follow.
+			return false, true
+		}
+
+		var next *dfaState
+		r := rune(s[i])
+		if r < utf8.RuneSelf {
+			next = current.ascii[r]
+			i++
+		} else {
+			var size int
+			r, size = utf8.DecodeRuneInString(s[i:])
+			next = current.next[r]
+			i += size
+		}
+
+		if next == nil {
+			if len(d.states) >= maxDFAStates {
+				return false, false
+			}
+			next = d.step(current, r)
+		}
+		current = next
+	}
+	return current.isFinal, true
+}
+
+// step builds the transition of from on r and returns the state it leads to.
+func (d *dfa) step(from *dfaState, r rune) *dfaState {
+	var targets []*state
+	for _, st := range from.nfaStates {
+		// As in the NFA, an edge for r overrides the anyChar transitions, which lets '.' exclude the newline.
+		if next := st.edges[r]; next != nil {
+			targets = append(targets, next...)
+		} else if st.anyChar != nil && st.acceptsAny(r) {
+			targets = append(targets, st.anyChar...)
+		}
+	}
+
+	to := d.state(targets)
+	if r < utf8.RuneSelf {
+		from.ascii[r] = to
+	} else {
+		if from.next == nil {
+			from.next = map[rune]*dfaState{}
+		}
+		from.next[r] = to
+	}
+	return to
+}
+
+// state returns the dfaState for the epsilon closure of the given NFA states, building it if it is new.
+// States with the same closure are the same dfaState, which keeps the dfa finite.
+func (d *dfa) state(targets []*state) *dfaState {
+	seen := map[*state]bool{}
+	var closure []*state
+	var close func(st *state)
+	close = func(st *state) {
+		if seen[st] {
+			return
+		}
+		seen[st] = true
+		closure = append(closure, st)
+		for _, next := range st.epsilon {
+			close(next)
+		}
+	}
+	for _, st := range targets {
+		close(st)
+	}
+
+	slices.SortFunc(closure, func(a, b *state) int { return a.id - b.id })
+	ids := make([]string, len(closure))
+	for i, st := range closure {
+		ids[i] = strconv.Itoa(st.id)
+	}
+	key := strings.Join(ids, ",")
+	if ds, ok := d.states[key]; ok {
+		return ds
+	}
+
+	ds := &dfaState{nfaStates: closure}
+	for _, st := range closure {
+		ds.isFinal = ds.isFinal || st.isFinal
+	}
+	d.states[key] = ds
+	return ds
+}
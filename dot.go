@@ -0,0 +1,124 @@
+package re
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteDOT writes the NFA of the regular expression to w as a GraphViz DOT graph, which can be rendered with
+// "dot -Tsvg". States are labeled with their ids, final states are drawn as double circles, and epsilon
+// transitions are dashed. A transition on a class of runes, like a set or '.', is labeled "class", and
+// a state that checks an assertion, a backreference or a conditional group, searches a separate NFA
+// or records a capture says so in its label.
+// The separate NFAs, which implement possessive quantifiers and lookaround assertions, are not drawn.
+func (re *Regexp) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph nfa {\n\trankdir=LR;\n\tnode [shape=circle];\n")
+
+	seen := map[*state]bool{}
+	var write func(st *state)
+	write = func(st *state) {
+		if seen[st] {
+			return
+		}
+		seen[st] = true
+		fmt.Fprintf(&sb, "\t%s [label=%s%s];\n", dotName(st), strconv.Quote(dotLabel(st)), dotShape(st))
+
+		runes := make([]rune, 0, len(st.edges))
+		for r := range st.edges {
+			runes = append(runes, r)
+		}
+		slices.Sort(runes)
+
+		for _, r := range runes {
+			for _, target := range st.edges[r] {
+				fmt.Fprintf(&sb, "\t%s -> %s [label=%s];\n", dotName(st), dotName(target), strconv.Quote(quoteRune(r)))
+			}
+		}
+		for _, target := range st.anyChar {
+			label := "any"
+			if st.accepts != nil {
+				label = "class"
+			}
+			fmt.Fprintf(&sb, "\t%s -> %s [label=%q];\n", dotName(st), dotName(target), label)
+		}
+		for _, target := range st.epsilon {
+			fmt.Fprintf(&sb, "\t%s -> %s [style=dashed];\n", dotName(st), dotName(target))
+		}
+
+		for _, r := range runes {
+			for _, target := range st.edges[r] {
+				write(target)
+			}
+		}
+		for _, target := range st.anyChar {
+			write(target)
+		}
+		for _, target := range st.epsilon {
+			write(target)
+		}
+	}
+	write(re.nfa.start)
+
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotName returns the identifier of a state in the DOT graph.
+func dotName(st *state) string {
+	return "s" + strconv.Itoa(st.id)
+}
+
+// dotLabel returns the label of a state in the DOT graph: its id, followed by what it does besides transitions.
+func dotLabel(st *state) string {
+	label := strconv.Itoa(st.id)
+	if st.assert != nil {
+		label += " assert"
+	}
+	if st.backref > 0 {
+		label += " \\" + strconv.Itoa(st.backref)
+	}
+	if st.groupSet > 0 {
+		label += " if " + strconv.Itoa(st.groupSet)
+	}
+	if st.groupUnset > 0 {
+		label += " unless " + strconv.Itoa(st.groupUnset)
+	}
+	if st.sub != nil {
+		label += " " + dotSubSearch(st.sub)
+	}
+	if st.openGroup > 0 {
+		label += " (" + strconv.Itoa(st.openGroup)
+	}
+	if st.closeGroup > 0 {
+		label += " " + strconv.Itoa(st.closeGroup) + ")"
+	}
+	return label
+}
+
+// dotSubSearch describes the search of a separate NFA that a state runs.
+func dotSubSearch(sub *subSearch) string {
+	kind := "atomic"
+	switch sub.kind {
+	case lookahead:
+		kind = "lookahead"
+	case lookbehind:
+		kind = "lookbehind"
+	}
+	if sub.negated {
+		return "negative " + kind
+	}
+	return kind
+}
+
+// dotShape returns the attribute that draws a final state as a double circle, or nothing for other states.
+func dotShape(st *state) string {
+	if st.isFinal {
+		return ", shape=doublecircle"
+	}
+	return ""
+}
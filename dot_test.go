@@ -0,0 +1,59 @@
+package re
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	tests := []struct {
+		pattern string
+		nodes   int
+		edges   int
+		dashed  int
+		final   int
+	}{
+		// The literal's start and end states, plus the fresh end state of the plus.
+		{"a+", 3, 3, 2, 1},
+		// Fresh start and end states of the star around the literal's two states.
+		{"a*", 4, 4, 3, 1},
+		{"ab", 4, 3, 1, 1},
+		{"(a|b)", 6, 6, 4, 1},
+		{"", 1, 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			var sb strings.Builder
+			if err := MustCompile(tt.pattern).WriteDOT(&sb); err != nil {
+				t.Fatalf("WriteDOT returned error: %v", err)
+			}
+
+			dot := sb.String()
+			if !strings.HasPrefix(dot, "digraph nfa {\n") || !strings.HasSuffix(dot, "}\n") {
+				t.Fatalf("WriteDOT(%q) is not a digraph:\n%s", tt.pattern, dot)
+			}
+
+			nodes, edges := 0, 0
+			for _, line := range strings.Split(dot, "\n") {
+				if strings.Contains(line, "->") {
+					edges++
+				} else if strings.Contains(line, "[label=") {
+					nodes++
+				}
+			}
+			dashed := strings.Count(dot, "style=dashed")
+			final := strings.Count(dot, "shape=doublecircle")
+			if nodes != tt.nodes || edges != tt.edges || dashed != tt.dashed || final != tt.final {
+				t.Errorf("WriteDOT(%q) has %d nodes, %d edges, %d dashed, %d final; want %d, %d, %d, %d:\n%s",
+					tt.pattern, nodes, edges, dashed, final, tt.nodes, tt.edges, tt.dashed, tt.final, dot)
+			}
+		})
+	}
+
+	var sb strings.Builder
+	MustCompile("a+").WriteDOT(&sb)
+	if !strings.Contains(sb.String(), `[label="a"]`) {
+		t.Errorf("WriteDOT(%q) does not label the transition on 'a':\n%s", "a+", sb.String())
+	}
+}
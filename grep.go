@@ -0,0 +1,364 @@
+package re
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences wrapped around matched text when color is enabled.
+const (
+	colorStart = "\x1b[01;31m"
+	colorEnd   = "\x1b[m"
+)
+
+// GrepOptions controls how Grep selects and prints lines.
+type GrepOptions struct {
+	Invert       bool   // select the lines that do not match instead of those that do
+	WordRegexp   bool   // only count matches that form whole words, with no word character just before or after them
+	Count        bool   // print the number of selected lines instead of the lines themselves
+	CountMatches bool   // print the number of non-empty matches in selected lines instead of the lines themselves
+	OnlyMatching bool   // print each non-empty match in selected lines on a line of its own instead of the lines
+	LineNumbers  bool   // prefix each selected line with its line number, counting from 1
+	MaxCount     int    // stop reading after this many selected lines; 0 means no limit
+	Label        string // if not empty, prefix each line of output with the label and a colon, like a filename
+	Color        bool   // highlight the matches in selected lines with ANSI escape sequences, instead of Pre and Post
+	Pre          string // if Pre or Post is not empty, write Pre before and Post after each match in selected lines
+	Post         string
+	NullData     bool // read and write records terminated by NUL bytes instead of lines
+	CacheSize    int  // remember whether the last CacheSize distinct lines matched, to skip matching repeated lines
+	KeepCR       bool // keep a carriage return before the newline at the end of a line instead of dropping it
+
+	// Context lines are printed around selected lines, marked with '-' instead of ':' after the label and line
+	// number. They are not printed with the counts or with OnlyMatching.
+	Before         int    // print this many lines before each selected line
+	After          int    // print this many lines after each selected line, even after MaxCount is reached
+	GroupSeparator string // if not empty, print this line between groups of lines that are not adjacent
+}
+
+// contextLine is a line kept to be printed as context before the next selected line.
+type contextLine struct {
+	number int
+	text   string
+}
+
+// Grep reads lines from r and writes those that contain a match of the regular expression to w,
+// each followed by a newline, or those that do not when opts.Invert is set. Lines may be of any length.
+// It returns the number of selected lines, even when printing the number of matches, and the first error
+// met while reading or writing, if any.
+func Grep(r io.Reader, w io.Writer, re *Regexp, opts GrepOptions) (int, error) {
+	separator := byte('\n')
+	if opts.NullData {
+		separator = 0
+	}
+	split := splitRecords(separator)
+	if opts.KeepCR {
+		split = splitTerminated(separator)
+	}
+
+	prefix := ""
+	if opts.Label != "" {
+		prefix = opts.Label + ":"
+	}
+
+	contextual := (opts.Before > 0 || opts.After > 0) && !opts.Count && !opts.CountMatches && !opts.OnlyMatching
+	var before []contextLine
+	afterLeft, lastPrinted := 0, 0
+
+	// writeLine writes a line of output for the given line of the input; mark is ":" for a selected line
+	// and "-" for context. A group separator goes first if lines were skipped since the last one printed.
+	writeLine := func(lineNumber int, output, mark string) error {
+		var sb strings.Builder
+		if contextual && opts.GroupSeparator != "" && lastPrinted > 0 && lineNumber > lastPrinted+1 {
+			sb.WriteString(opts.GroupSeparator + string(separator))
+		}
+		if opts.Label != "" {
+			sb.WriteString(opts.Label + mark)
+		}
+		if opts.LineNumbers {
+			sb.WriteString(strconv.Itoa(lineNumber) + mark)
+		}
+		sb.WriteString(output + string(separator))
+		lastPrinted = lineNumber
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+
+	pre, post := opts.Pre, opts.Post
+	if opts.Color {
+		pre, post = colorStart, colorEnd
+	}
+	marked := pre != "" || post != ""
+
+	count, matches := 0, 0
+	literal, complete := re.LiteralPrefix()
+	contains := strings.Contains
+	if re.anchored {
+		contains = strings.HasPrefix
+	}
+	matcher := re.NewMatcher()
+	var cache *lineCache
+	if opts.CacheSize > 0 {
+		cache = newLineCache(opts.CacheSize)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, math.MaxInt)
+	scanner.Split(split)
+	done := false
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if done {
+			// MaxCount lines have been selected; only the context after the last one is left to print.
+			if err := writeLine(lineNumber, line, "-"); err != nil {
+				return count, err
+			}
+			if afterLeft--; afterLeft == 0 {
+				break
+			}
+			continue
+		}
+
+		selected, cached := cache.get(line)
+		if !cached {
+			// A line without the literal prefix of every match needs no search, and for a literal pattern
+			// finding the prefix is the whole search.
+			if !contains(line, literal) {
+				selected = false
+			} else if complete && !opts.WordRegexp {
+				selected = true
+			} else if opts.WordRegexp {
+				selected = len(wordMatches(re, line)) > 0
+			} else {
+				selected = matcher.MatchString(line)
+			}
+			cache.add(line, selected)
+		}
+		if selected == opts.Invert {
+			if !contextual {
+				continue
+			} else if afterLeft > 0 {
+				afterLeft--
+				if err := writeLine(lineNumber, line, "-"); err != nil {
+					return count, err
+				}
+			} else if opts.Before > 0 {
+				before = append(before, contextLine{lineNumber, line})
+				if len(before) > opts.Before {
+					before = before[1:]
+				}
+			}
+			continue
+		}
+
+		count++
+		if opts.CountMatches {
+			for _, match := range findAll(re, line, opts.WordRegexp) {
+				if match[0] != match[1] {
+					matches++
+				}
+			}
+		} else if !opts.Count {
+			for _, context := range before {
+				if err := writeLine(context.number, context.text, "-"); err != nil {
+					return count, err
+				}
+			}
+			before, afterLeft = before[:0], opts.After
+
+			outputs := []string{line}
+			if opts.OnlyMatching {
+				// Selected lines of an inverted search have no matches to print.
+				outputs = onlyMatching(line, findAll(re, line, opts.WordRegexp), pre, post)
+			} else if marked && !opts.Invert {
+				outputs[0] = highlight(line, findAll(re, line, opts.WordRegexp), pre, post)
+			}
+			for _, output := range outputs {
+				if err := writeLine(lineNumber, output, ":"); err != nil {
+					return count, err
+				}
+			}
+		}
+
+		if count == opts.MaxCount {
+			if !contextual || afterLeft == 0 {
+				break
+			}
+			done = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	if opts.Count || opts.CountMatches {
+		total := count
+		if opts.CountMatches {
+			total = matches
+		}
+		if _, err := io.WriteString(w, prefix+strconv.Itoa(total)+"\n"); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// MatchScanner returns the tokens of sc that contain a match of the regular expression, in the order sc yields
+// them. The scanner decides what a token is, so the same loop finds matching lines, words or records.
+// It returns the tokens found so far and the error of sc if scanning fails.
+func MatchScanner(sc *bufio.Scanner, re *Regexp) ([]string, error) {
+	var matched []string
+	matcher := re.NewMatcher()
+	for sc.Scan() {
+		if token := sc.Text(); matcher.MatchString(token) {
+			matched = append(matched, token)
+		}
+	}
+	return matched, sc.Err()
+}
+
+// lineCache remembers whether recently seen lines matched, evicting the least recently used line once it holds
+// size lines. The methods of a nil lineCache do nothing, so a search without a cache needs no special case.
+type lineCache struct {
+	size    int
+	order   *list.List // of *lineCacheEntry, the most recently used first
+	entries map[string]*list.Element
+}
+
+// lineCacheEntry is the result of matching a line, kept in a lineCache.
+type lineCacheEntry struct {
+	line     string
+	selected bool
+}
+
+// newLineCache returns an empty lineCache that holds up to size lines.
+func newLineCache(size int) *lineCache {
+	return &lineCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the result remembered for line and true, or false as its second result if line is not in the cache.
+func (c *lineCache) get(line string) (bool, bool) {
+	if c == nil {
+		return false, false
+	}
+	elem, ok := c.entries[line]
+	if !ok {
+		return false, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lineCacheEntry).selected, true
+}
+
+// add remembers the result for line, which must not be in the cache, evicting the least recently used line if full.
+func (c *lineCache) add(line string, selected bool) {
+	if c == nil {
+		return
+	}
+	c.entries[line] = c.order.PushFront(&lineCacheEntry{line: line, selected: selected})
+	if c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*lineCacheEntry)
+		delete(c.entries, oldest.line)
+	}
+}
+
+// findAll returns the offsets of the matches of the regular expression in line, as FindAllStringIndex does,
+// or those of the whole-word matches found by wordMatches if words is set.
+func findAll(re *Regexp, line string, words bool) [][]int {
+	if words {
+		return wordMatches(re, line)
+	}
+	return re.FindAllStringIndex(line, -1)
+}
+
+// wordMatches returns the offsets of successive non-overlapping matches in line that form whole words:
+// the runes just before and after a match must not be word characters, and the edges of the line count as non-word.
+// A match that fails the check is skipped, and the search goes on from the rune after its start.
+func wordMatches(re *Regexp, line string) [][]int {
+	input := stringSource(line)
+	m := &machine{}
+	var matches [][]int
+	for pos := 0; pos <= len(line); {
+		loc := re.find(m, input, pos, 2)
+		if loc == nil {
+			break
+		}
+
+		before, _ := utf8.DecodeLastRuneInString(line[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(line[loc[1]:])
+		if !isWordChar(before) && !isWordChar(after) {
+			matches = append(matches, loc)
+			if loc[1] > loc[0] {
+				pos = loc[1]
+				continue
+			}
+		}
+
+		if loc[0] == len(line) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(line[loc[0]:])
+		pos = loc[0] + size
+	}
+	return matches
+}
+
+// highlight wraps every non-empty match in line, given by its offsets, with pre and post.
+func highlight(line string, matches [][]int, pre, post string) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range matches {
+		if match[0] == match[1] {
+			continue
+		}
+		sb.WriteString(line[last:match[0]])
+		sb.WriteString(pre + line[match[0]:match[1]] + post)
+		last = match[1]
+	}
+	sb.WriteString(line[last:])
+	return sb.String()
+}
+
+// onlyMatching returns the text of every non-empty match in line, given by its offsets, wrapped in pre and post.
+func onlyMatching(line string, matches [][]int, pre, post string) []string {
+	var outputs []string
+	for _, loc := range matches {
+		match := line[loc[0]:loc[1]]
+		if match == "" {
+			continue
+		}
+		outputs = append(outputs, pre+match+post)
+	}
+	return outputs
+}
+
+// splitRecords returns a split function for bufio.Scanner that yields the records of the input terminated by
+// separator, without the separator. The last record need not be terminated. For newline-separated input,
+// a carriage return before the newline is dropped too, as bufio.ScanLines does.
+func splitRecords(separator byte) bufio.SplitFunc {
+	if separator == '\n' {
+		return bufio.ScanLines
+	}
+	return splitTerminated(separator)
+}
+
+// splitTerminated returns a split function for bufio.Scanner that yields the records of the input terminated by
+// separator, without the separator but with everything else, even a carriage return before a newline.
+func splitTerminated(separator byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, separator); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
@@ -0,0 +1,285 @@
+package re
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGrep(t *testing.T) {
+	input := "apple\nbanana\ncherry\navocado\n"
+	tests := []struct {
+		name    string
+		pattern string
+		opts    GrepOptions
+		in      string
+		out     string
+		count   int
+	}{
+		{"match", "an", GrepOptions{}, input, "banana\n", 1},
+		{"no match", "x", GrepOptions{}, input, "", 0},
+		{"invert", "a", GrepOptions{Invert: true}, input, "cherry\n", 1},
+		{"count", "a", GrepOptions{Count: true}, input, "3\n", 3},
+		{"count no match", "x", GrepOptions{Count: true}, input, "0\n", 0},
+		{"count invert", "^a", GrepOptions{Count: true, Invert: true}, input, "2\n", 2},
+		{"count matches", "an", GrepOptions{CountMatches: true}, input, "2\n", 1},
+		{"count matches skips empty matches", "x*", GrepOptions{CountMatches: true}, input, "0\n", 4},
+		{"count matches with label", "a", GrepOptions{CountMatches: true, Label: "fruit.txt"}, input, "fruit.txt:6\n", 3},
+		{"only matching", "an", GrepOptions{OnlyMatching: true}, input, "an\nan\n", 1},
+		{"only matching skips empty matches", "c*", GrepOptions{OnlyMatching: true}, input, "c\nc\n", 4},
+		{"only matching with color", "a.", GrepOptions{OnlyMatching: true, Color: true, LineNumbers: true}, input,
+			"1:\x1b[01;31map\x1b[m\n2:\x1b[01;31man\x1b[m\n2:\x1b[01;31man\x1b[m\n4:\x1b[01;31mav\x1b[m\n4:\x1b[01;31mad\x1b[m\n", 3},
+		{"only matching invert", "ch", GrepOptions{OnlyMatching: true, Invert: true}, input, "", 3},
+		{"only matching with count", "an", GrepOptions{OnlyMatching: true, Count: true}, input, "1\n", 1},
+		{"word", "cat", GrepOptions{WordRegexp: true}, "a cat.\nscatter\ncat\ncats\n", "a cat.\ncat\n", 2},
+		{"word after a failed match", "cat", GrepOptions{WordRegexp: true}, "cats cat\n", "cats cat\n", 1},
+		{"word invert", "cat", GrepOptions{WordRegexp: true, Invert: true}, "a cat.\nscatter\n", "scatter\n", 1},
+		{"word only matching", "c[a-z]*", GrepOptions{WordRegexp: true, OnlyMatching: true}, "scat cot cup_\n", "cot\n", 1},
+		{"word count matches", "a+", GrepOptions{WordRegexp: true, CountMatches: true}, "a aa ba a_ aa\n", "3\n", 1},
+		{"word color", "cat", GrepOptions{WordRegexp: true, Color: true}, "cats cat\n", "cats \x1b[01;31mcat\x1b[m\n", 1},
+		{"line numbers", "^a", GrepOptions{LineNumbers: true}, input, "1:apple\n4:avocado\n", 2},
+		{"max count", "a", GrepOptions{MaxCount: 2}, input, "apple\nbanana\n", 2},
+		{"max count with count", "a", GrepOptions{MaxCount: 2, Count: true}, input, "2\n", 2},
+		{"max count above matches", "a", GrepOptions{MaxCount: 10}, input, "apple\nbanana\navocado\n", 3},
+		{"label", "ch", GrepOptions{Label: "fruit.txt", LineNumbers: true}, input, "fruit.txt:3:cherry\n", 1},
+		{"label with count", "ch", GrepOptions{Label: "fruit.txt", Count: true}, input, "fruit.txt:1\n", 1},
+		{"color", "an", GrepOptions{Color: true}, input, "b\x1b[01;31man\x1b[m\x1b[01;31man\x1b[ma\n", 1},
+		{"color invert", "a", GrepOptions{Color: true, Invert: true}, input, "cherry\n", 1},
+		{"pre and post", "an", GrepOptions{Pre: "<", Post: ">"}, input, "b<an><an>a\n", 1},
+		{"post only", "^a", GrepOptions{Post: "|", OnlyMatching: true}, input, "a|\na|\n", 2},
+		{"null data", "a", GrepOptions{NullData: true}, "a\nb\x00c\x00", "a\nb\x00", 1},
+		{"unterminated last line", "c", GrepOptions{}, "a\nbc", "bc\n", 1},
+		{"literal", "an", GrepOptions{Invert: true}, input, "apple\ncherry\navocado\n", 3},
+		{"literal prefix", "ch+er", GrepOptions{}, input, "cherry\n", 1},
+		{"literal replacement character", "\uFFFD", GrepOptions{}, "a\xffb\nc\n", "a\xffb\n", 1},
+		{"literal word", "cherry", GrepOptions{WordRegexp: true}, "cherry\ncherrypie\n", "cherry\n", 1},
+		{"carriage returns", "b$", GrepOptions{}, "ab\r\ncd\r\n", "ab\n", 1},
+		{"keep carriage returns", "b.$", GrepOptions{KeepCR: true}, "ab\r\ncd\r\n", "ab\r\n", 1},
+		{"after context", "^b", GrepOptions{After: 1}, input, "banana\ncherry\n", 1},
+		{"before context", "^c", GrepOptions{Before: 5, LineNumbers: true}, input, "1-apple\n2-banana\n3:cherry\n", 1},
+		{"context with label", "^b", GrepOptions{Before: 1, Label: "f"}, input, "f-apple\nf:banana\n", 1},
+		{"overlapping context", "^[bc]", GrepOptions{Before: 1, After: 1}, input, "apple\nbanana\ncherry\navocado\n", 2},
+		{"group separator", "x", GrepOptions{After: 1, GroupSeparator: "--"}, "x1\na\nb\nx2\nc\n", "x1\na\n--\nx2\nc\n", 2},
+		{"adjacent groups", "x", GrepOptions{After: 1, GroupSeparator: "--"}, "x1\na\nx2\n", "x1\na\nx2\n", 2},
+		{"no group separator", "x", GrepOptions{Before: 1}, "a\nb\nx1\nc\nd\nx2\n", "b\nx1\nd\nx2\n", 2},
+		{"context after max count", "x", GrepOptions{MaxCount: 1, After: 2}, "x1\nx2\na\nb\n", "x1\nx2\na\n", 1},
+		{"context with count", "x", GrepOptions{After: 1, Count: true}, "x1\na\n", "1\n", 1},
+		{"context invert", "x", GrepOptions{Invert: true, After: 1, GroupSeparator: "--"}, "a\nx\nx\nx\nb\n", "a\nx\n--\nb\n", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			count, err := Grep(strings.NewReader(tt.in), &out, MustCompile(tt.pattern), tt.opts)
+			if err != nil {
+				t.Fatalf("Grep returned error: %v", err)
+			}
+			if count != tt.count || out.String() != tt.out {
+				t.Errorf("Grep(%q, %+v) = %d, %q; want %d, %q", tt.pattern, tt.opts, count, out.String(), tt.count, tt.out)
+			}
+		})
+	}
+}
+
+func TestMatchScanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		in      string
+		split   bufio.SplitFunc
+		want    []string
+	}{
+		{"lines", "an", "apple\nbanana\ncherry\nmango\n", bufio.ScanLines, []string{"banana", "mango"}},
+		{"no match", "x", "apple\nbanana\n", bufio.ScanLines, nil},
+		{"words", "^c", "cat dog\ncow  bird", bufio.ScanWords, []string{"cat", "cow"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := bufio.NewScanner(strings.NewReader(tt.in))
+			sc.Split(tt.split)
+			got, err := MatchScanner(sc, MustCompile(tt.pattern))
+			if err != nil {
+				t.Fatalf("MatchScanner returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatchScanner(%q) = %q; want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchScannerError(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("match\n" + strings.Repeat("x", 100) + "\nmatch\n"))
+	sc.Buffer(nil, 16)
+	got, err := MatchScanner(sc, MustCompile("match"))
+	if err != bufio.ErrTooLong {
+		t.Errorf("MatchScanner error = %v; want %v", err, bufio.ErrTooLong)
+	}
+	if !reflect.DeepEqual(got, []string{"match"}) {
+		t.Errorf("MatchScanner = %q; want the lines before the error", got)
+	}
+}
+
+// failingWriter is an io.Writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestGrepLongLine(t *testing.T) {
+	line := strings.Repeat("x", 1<<20) + "needle"
+	var out strings.Builder
+	count, err := Grep(strings.NewReader("hay\n"+line+"\nhay\n"), &out, MustCompile("needle$"), GrepOptions{LineNumbers: true})
+	if err != nil {
+		t.Fatalf("Grep returned error: %v", err)
+	}
+	if count != 1 || out.String() != "2:"+line+"\n" {
+		t.Errorf("Grep = %d, %d bytes; want 1, %d bytes", count, out.Len(), len(line)+3)
+	}
+}
+
+func TestGrepAnchored(t *testing.T) {
+	re, err := CompileFlags("ab", Anchored)
+	if err != nil {
+		t.Fatalf("CompileFlags returned error: %v", err)
+	}
+	var out strings.Builder
+	count, err := Grep(strings.NewReader("xab\nab\nabc\n"), &out, re, GrepOptions{})
+	if err != nil || count != 2 || out.String() != "ab\nabc\n" {
+		t.Errorf("Grep with an anchored literal = %d, %q, %v; want 2, %q, nil", count, out.String(), err, "ab\nabc\n")
+	}
+}
+
+func TestGrepWriteError(t *testing.T) {
+	count, err := Grep(strings.NewReader("a\na\n"), failingWriter{}, MustCompile("a"), GrepOptions{})
+	if err == nil || err.Error() != "disk full" || count != 1 {
+		t.Errorf("Grep to a failing writer = %d, %v; want 1, disk full", count, err)
+	}
+}
+
+func TestGrepCache(t *testing.T) {
+	input := "apple\nbanana\napple\ncherry\nbanana\napple\ncherry\ncherry\n"
+	tests := []struct {
+		pattern string
+		opts    GrepOptions
+	}{
+		{"an", GrepOptions{}},
+		{"a", GrepOptions{Invert: true, LineNumbers: true}},
+		{"p+", GrepOptions{OnlyMatching: true}},
+		{"e", GrepOptions{CountMatches: true}},
+		{"apple", GrepOptions{WordRegexp: true, Color: true}},
+	}
+
+	for _, tt := range tests {
+		var want strings.Builder
+		wantCount, _ := Grep(strings.NewReader(input), &want, MustCompile(tt.pattern), tt.opts)
+		// A cache of one or two lines evicts lines that come back later, and a larger one keeps them all.
+		for _, size := range []int{1, 2, 100} {
+			opts := tt.opts
+			opts.CacheSize = size
+			var got strings.Builder
+			count, err := Grep(strings.NewReader(input), &got, MustCompile(tt.pattern), opts)
+			if err != nil || count != wantCount || got.String() != want.String() {
+				t.Errorf("Grep(%q, %+v) = %d, %q, %v; want %d, %q as without a cache",
+					tt.pattern, opts, count, got.String(), err, wantCount, want.String())
+			}
+		}
+	}
+}
+
+func TestLineCache(t *testing.T) {
+	cache := newLineCache(2)
+	cache.add("a", true)
+	cache.add("b", false)
+	if selected, ok := cache.get("a"); !selected || !ok {
+		t.Errorf("get(%q) = %v, %v; want true, true", "a", selected, ok)
+	}
+	// "b" is now the least recently used line, so adding a third line evicts it.
+	cache.add("c", true)
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("get(%q) found an evicted line", "b")
+	}
+	if selected, ok := cache.get("c"); !selected || !ok {
+		t.Errorf("get(%q) = %v, %v; want true, true", "c", selected, ok)
+	}
+	if len(cache.entries) != 2 || cache.order.Len() != 2 {
+		t.Errorf("cache holds %d entries in a list of %d; want 2", len(cache.entries), cache.order.Len())
+	}
+
+	var none *lineCache
+	none.add("a", true)
+	if _, ok := none.get("a"); ok {
+		t.Errorf("a nil cache found a line")
+	}
+}
+
+// BenchmarkGrepCache searches a log whose lines repeat a few messages, with and without a cache.
+func BenchmarkGrepCache(b *testing.B) {
+	messages := []string{
+		"INFO request served in 12ms path=/index.html status=200",
+		"WARN slow request served in 950ms path=/search status=200",
+		"INFO request served in 8ms path=/style.css status=200",
+		"ERROR request failed path=/api/items status=500",
+	}
+	var sb strings.Builder
+	for i := range 10000 {
+		sb.WriteString(messages[i%len(messages)] + "\n")
+	}
+	input := sb.String()
+	// Word boundaries keep the pattern off the DFA, so every line is searched by backtracking.
+	re := MustCompile("\\bstatus=5\\d\\d\\b|served in \\d{3,}ms")
+
+	for _, size := range []int{0, 1024} {
+		b.Run("CacheSize="+strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				Grep(strings.NewReader(input), io.Discard, re, GrepOptions{CacheSize: size})
+			}
+		})
+	}
+}
+
+// BenchmarkGrepLiteralPrefix searches a log where few lines can match, with a pattern that starts with a literal
+// and with the same pattern behind a group, which hides the prefix, so every line is searched.
+func BenchmarkGrepLiteralPrefix(b *testing.B) {
+	var sb strings.Builder
+	for i := range 10000 {
+		if i%100 == 0 {
+			sb.WriteString("ERROR request failed path=/api/items status=500\n")
+		} else {
+			sb.WriteString("INFO request served in 12ms path=/index.html status=200\n")
+		}
+	}
+	input := sb.String()
+
+	for _, pattern := range []string{"ERROR \\b\\w+", "(?:ERROR) \\b\\w+"} {
+		re := MustCompile(pattern)
+		b.Run(pattern, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				Grep(strings.NewReader(input), io.Discard, re, GrepOptions{})
+			}
+		})
+	}
+}
+
+// BenchmarkWordMatchesLongLine finds the whole-word matches in lines of growing length, where most matches
+// are rejected because they are part of a longer word. The throughput should stay flat as the line grows.
+func BenchmarkWordMatchesLongLine(b *testing.B) {
+	re := MustCompile("a")
+	for _, n := range []int{1000, 16000} {
+		line := strings.Repeat("ab a ", n)
+		b.Run("Words="+strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			for i := 0; i < b.N; i++ {
+				if got := len(wordMatches(re, line)); got != n {
+					b.Fatalf("found %d whole-word matches; want %d", got, n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package re
+
+import "strings"
+
+// Matcher matches one Regexp against many inputs in turn, such as the lines of a file.
+// It keeps the scratch space of the search between calls, so matching another input allocates little
+// beyond preparing the input itself. A Matcher can be reused any number of times, one call after another,
+// but must not be used by several goroutines at once; give each goroutine its own Matcher instead.
+//
+// For a pattern without anchors, word boundaries, lookaround or backreferences, MatchString runs a DFA
+// that the Matcher builds as the inputs need it, which scans each input in a single pass without allocating.
+type Matcher struct {
+	re      *Regexp
+	machine machine
+	caps    []int
+	dfa     *dfa
+}
+
+// NewMatcher returns a Matcher for the regular expression.
+func (re *Regexp) NewMatcher() *Matcher {
+	m := &Matcher{re: re, caps: make([]int, 2)}
+	if re.simple {
+		m.dfa = newDFA(re.searchNfa)
+	}
+	return m
+}
+
+// MatchString reports whether the string s contains any match of the regular expression.
+func (m *Matcher) MatchString(s string) bool {
+	if !strings.Contains(s, m.re.required) {
+		return false
+	}
+	if m.dfa != nil {
+		if matched, ok := m.dfa.matchString(s); ok {
+			return matched
+		}
+		// The DFA has grown too large for this pattern, so the NFA takes over from now on.
+		m.dfa = nil
+	}
+	return m.re.search(&m.machine, stringSource(s), 0, m.caps)
+}
+
+// FindStringIndex returns the start and end byte offsets of the leftmost match in s, or nil if there is no match.
+func (m *Matcher) FindStringIndex(s string) []int {
+	if !m.re.search(&m.machine, stringSource(s), 0, m.caps) {
+		return nil
+	}
+	return []int{m.caps[0] - bosWidth, m.caps[1] - bosWidth}
+}
@@ -0,0 +1,225 @@
+package re
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		s     string
+		index []int
+	}{
+		{"the lazy dog", []int{4, 8}},
+		{"no match here", nil},
+		{strings.Repeat("x ", 500) + "lazy", []int{1000, 1004}},
+		{"lazy", []int{0, 4}},
+		{"", nil},
+		{"a lazier cat", nil},
+	}
+
+	re := MustCompile(`\blazy\b`)
+	m := re.NewMatcher()
+	for _, tt := range tests {
+		if got := m.MatchString(tt.s); got != (tt.index != nil) {
+			t.Errorf("MatchString(%q) = %v; want %v", tt.s, got, tt.index != nil)
+		}
+		if got := m.FindStringIndex(tt.s); !reflect.DeepEqual(got, tt.index) {
+			t.Errorf("FindStringIndex(%q) = %v; want %v", tt.s, got, tt.index)
+		}
+		if got := re.FindStringIndex(tt.s); !reflect.DeepEqual(got, tt.index) {
+			t.Errorf("Regexp.FindStringIndex(%q) = %v; want %v", tt.s, got, tt.index)
+		}
+	}
+}
+
+func TestMatcherLongest(t *testing.T) {
+	re := MustCompile("a|ab")
+	re.Longest()
+	m := re.NewMatcher()
+	for range 2 {
+		if got := m.FindStringIndex("xab"); !reflect.DeepEqual(got, []int{1, 3}) {
+			t.Errorf("FindStringIndex(%q) = %v; want [1 3]", "xab", got)
+		}
+	}
+}
+
+func TestMatcherDFA(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dfa     bool
+	}{
+		{"lazy", true},
+		{"l[a-z]+y", true},
+		{"(cat|dog)s?", true},
+		{"\\d{2,3}x", true},
+		{"[^a-z]本.", true},
+		{"\\p{Greek}+", true},
+		{"x*", true},
+		{"", true},
+		{"(?s)a.b", true},
+		{"a.b", true},
+		{".+", true},
+		{"^lazy", false},
+		{"lazy$", false},
+		{"\\blazy", false},
+		{"la(?=z)", false},
+		{"(a)\\1", false},
+		{"a++b", false},
+	}
+	inputs := []string{"", "lazy", "a lazy dog", "the cats", "dogs", "x123x", "x12x", "Z本語", "本本", "αβγ", "a\nb", "aab", "aaa", "lazzy"}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			m := re.NewMatcher()
+			if (m.dfa != nil) != tt.dfa {
+				t.Fatalf("NewMatcher().dfa != nil is %v; want %v", m.dfa != nil, tt.dfa)
+			}
+			for _, s := range inputs {
+				if got, want := m.MatchString(s), re.MatchString(s); got != want {
+					t.Errorf("Matcher.MatchString(%q) = %v; want %v", s, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatcherDFAFallback(t *testing.T) {
+	// Telling apart the last 15 runes of a random line needs more DFA states than the limit allows.
+	re := MustCompile("(a|b)*a(a|b){14}c")
+	m := re.NewMatcher()
+	rng := rand.New(rand.NewPCG(1, 2))
+	var sb strings.Builder
+	for range 100000 {
+		sb.WriteByte("ab"[rng.IntN(2)])
+	}
+	line := sb.String()
+
+	if got, want := m.MatchString(line), re.MatchString(line); got != want {
+		t.Errorf("MatchString = %v; want %v", got, want)
+	}
+	if m.dfa != nil {
+		t.Errorf("the DFA was kept after growing past %d states", maxDFAStates)
+	}
+	if got := m.MatchString("b" + strings.Repeat("a", 15) + "c"); !got {
+		t.Errorf("MatchString after falling back = false; want true")
+	}
+}
+
+func TestMatcherInputExhausted(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"abc", "ab", false},
+		{"ab.", "ab", false},
+		{"a\\x{FFFD}", "a", false},
+		{"a[^a]", "a", false},
+		{"a[^a]", "a\xff", true},
+	}
+
+	for _, tt := range tests {
+		m := MustCompile(tt.pattern).NewMatcher()
+		if m.dfa == nil {
+			t.Fatalf("MustCompile(%q).NewMatcher() has no DFA", tt.pattern)
+		}
+		if got := m.MatchString(tt.s); got != tt.want {
+			t.Errorf("MustCompile(%q).NewMatcher().MatchString(%q) = %v; want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// benchmarkLines is a file-sized input of lines, one in ten of which matches the benchmark pattern.
+var benchmarkLines = func() []string {
+	lines := make([]string, 10000)
+	for i := range lines {
+		if i%10 == 0 {
+			lines[i] = "ERROR: request failed with status 500"
+		} else {
+			lines[i] = "INFO: request served with status 200 in 12ms"
+		}
+	}
+	return lines
+}()
+
+func BenchmarkScanRegexp(b *testing.B) {
+	b.ReportAllocs()
+	re := MustCompile(`status 5\d\d`)
+	for i := 0; i < b.N; i++ {
+		for _, line := range benchmarkLines {
+			re.MatchString(line)
+		}
+	}
+}
+
+func BenchmarkScanMatcher(b *testing.B) {
+	b.ReportAllocs()
+	m := MustCompile(`status 5\d\d`).NewMatcher()
+	for i := 0; i < b.N; i++ {
+		for _, line := range benchmarkLines {
+			m.MatchString(line)
+		}
+	}
+}
+
+// BenchmarkScanRequiredLiteral matches a pattern that starts with a class, so it has no literal prefix, but
+// contains a literal that nine in ten lines lack, with and without the substring search that rejects them.
+func BenchmarkScanRequiredLiteral(b *testing.B) {
+	for _, bb := range []struct {
+		name      string
+		prefilter bool
+	}{
+		{"Prefilter", true},
+		{"NoPrefilter", false},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			re := MustCompile(`\w+ failed with status \d+`)
+			if !bb.prefilter {
+				re.required = ""
+			}
+			for i := 0; i < b.N; i++ {
+				for _, line := range benchmarkLines {
+					re.MatchString(line)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkLinesSize is the number of bytes in benchmarkLines, for reporting throughput.
+var benchmarkLinesSize = func() int64 {
+	size := 0
+	for _, line := range benchmarkLines {
+		size += len(line) + 1
+	}
+	return int64(size)
+}()
+
+func BenchmarkScanLiteral(b *testing.B) {
+	for _, bb := range []struct {
+		name   string
+		useDFA bool
+	}{
+		{"DFA", true},
+		{"NFA", false},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(benchmarkLinesSize)
+			m := MustCompile("failed").NewMatcher()
+			if !bb.useDFA {
+				m.dfa = nil
+			}
+			for i := 0; i < b.N; i++ {
+				for _, line := range benchmarkLines {
+					m.MatchString(line)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package re
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -13,11 +17,25 @@ const BOS = '\x02' // Beginning of string
 const EOS = '\x03' // End of string
 
 // parser is a simple regular expression parser.
+// The multiline flag is set by "(?m)" and makes '^' and '$' match at line boundaries.
+// The dotAll flag is set by "(?s)" and lets '.' match a newline.
+// The unicode flag is set by "(?u)" and makes '\d' and '\w' match digits and letters of any script.
+// numGroups counts the capturing groups opened so far, which numbers them in the order of their '('.
+// names holds the name of each of those groups, or "" for an unnamed one, in the same order.
+// inGroup is set for the parser of a group's contents, whose first token is the group itself.
+// At the top level, where there is no such group, branches collects the alternatives before each '|'.
 type parser struct {
-	regexp string
-	pos    int
-	tokens []token
-	done   bool
+	regexp    string
+	pos       int
+	tokens    []Token
+	branches  [][]Token
+	done      bool
+	inGroup   bool
+	multiline bool
+	dotAll    bool
+	unicode   bool
+	numGroups int
+	names     []string
 }
 
 // peek returns the next rune and its size in the input string without advancing the position.
@@ -43,6 +61,7 @@ func (p *parser) next() rune {
 
 // parse processes the entire regular expression string, parsing it into its constituent parts.
 // It returns an error if any part of the regular expression is invalid.
+// A pattern with top-level alternatives, like "ab|cd", becomes a single non-capturing group of them.
 func (p *parser) parse() error {
 	for p.pos < len(p.regexp) && !p.done {
 		err := p.parseRe()
@@ -50,6 +69,16 @@ func (p *parser) parse() error {
 			return err
 		}
 	}
+
+	if len(p.branches) > 0 {
+		payload := append(p.branches, p.tokens)
+		p.tokens = []Token{groupToken{payload: payload}}
+		p.branches = nil
+	}
+
+	if !p.inGroup && tokensSize(p.tokens) > maxSize {
+		return &SyntaxError{Msg: "expression too large", Pattern: p.regexp, Pos: 0}
+	}
 	return nil
 }
 
@@ -80,6 +109,8 @@ func (p *parser) parseRe() error {
 		err = p.parseStar()
 	case '?':
 		err = p.parseOptional()
+	case '{':
+		err = p.parseBraceQuantifier()
 	case '.':
 		err = p.parseWildcard()
 	case '^':
@@ -113,33 +144,163 @@ func (p *parser) parseLiteral() error {
 }
 
 // parseMetaChar reads the next rune from the input string and appends it as a meta character token to the tokens slice.
-// If the end of the input string is reached, it returns an error indicating an unexpected EOF.
-// If the meta character is not supported, it returns an error.
+// If the pattern ends right after the backslash, it returns an error reporting the trailing backslash.
+// If the meta character is not supported, it returns an error. A backreference to a group that has not been
+// opened before it is a SyntaxError at the backslash.
 func (p *parser) parseMetaChar() error {
+	start := p.pos
+	if p.next() != '\\' {
+		return errors.New("expected '\\' at the beginning of meta character")
+	}
+
 	nextChar := p.next()
 	if nextChar == EOF {
-		return errors.New("unexpected EOF while parsing meta character")
+		return errors.New("trailing backslash in pattern")
 	}
 
-	var token token
-	nextChar = p.next()
+	var token Token
 	switch nextChar {
 	case 'd':
-		token = digitToken{}
+		token = digitToken{unicode: p.unicode}
 	case 'w':
-		token = wordToken{}
-	case '\\':
-		token = literalToken{char: '\\'}
+		token = wordToken{unicode: p.unicode}
+	case 'b':
+		token = wordBoundaryToken{}
+	case 'B':
+		token = nonWordBoundaryToken{}
+	case 'A':
+		token = startOfInputToken{}
+	case 'z':
+		token = endOfInputToken{}
+	case 'x':
+		r, err := p.parseHexEscape()
+		if err != nil {
+			return err
+		}
+		token = literalToken{char: r}
+	case 'u':
+		r, err := p.parseHexDigits("unicode", 'u', 4)
+		if err != nil {
+			return err
+		}
+		token = literalToken{char: r}
+	case 'U':
+		r, err := p.parseHexDigits("unicode", 'U', 8)
+		if err != nil {
+			return err
+		}
+		token = literalToken{char: r}
+	case 'Q':
+		p.parseQuoted()
+		return nil
+	case 'p', 'P':
+		name, err := p.parsePropertyName(nextChar)
+		if err != nil {
+			return err
+		}
+		token = propertyToken{name: name, negated: nextChar == 'P'}
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		group := int(nextChar - '0')
+		if group > p.numGroups {
+			msg := fmt.Sprintf("invalid backreference: \\%c", nextChar)
+			return &SyntaxError{Msg: msg, Pattern: p.regexp, Pos: start}
+		}
+		token = backreferenceToken{group: group}
 	default:
-		return fmt.Errorf("unsupported meta character: \\%c", nextChar)
+		if !strings.ContainsRune(metaChars, nextChar) {
+			return fmt.Errorf("unsupported meta character: \\%c", nextChar)
+		}
+		token = literalToken{char: nextChar}
 	}
 
 	p.tokens = append(p.tokens, token)
 	return nil
 }
 
+// parsePropertyName parses the name of a Unicode property class whose "\\p" or "\\P" prefix has already been
+// consumed. The name is either a single letter, as in "\\pL", or braced, as in "\\p{Greek}", and must be
+// a Unicode general category or script.
+func (p *parser) parsePropertyName(letter rune) (string, error) {
+	rest := p.regexp[p.pos:]
+	var name string
+	if strings.HasPrefix(rest, "{") {
+		closing := strings.IndexRune(rest, '}')
+		if closing < 0 {
+			return "", fmt.Errorf("unclosed Unicode property: \\%c{", letter)
+		}
+		name = rest[1:closing]
+		p.pos += closing + 1
+	} else {
+		r, w := p.peek()
+		if r == EOF {
+			return "", fmt.Errorf("missing Unicode property name after \\%c", letter)
+		}
+		name = string(r)
+		p.pos += w
+	}
+
+	if propertyTable(name) == nil {
+		return "", fmt.Errorf("unknown Unicode property: %s", name)
+	}
+	return name, nil
+}
+
+// parseQuoted parses the text after "\\Q" up to the next "\\E", or to the end of the pattern if there is none,
+// as a sequence of literal characters.
+func (p *parser) parseQuoted() {
+	quoted, _, _ := strings.Cut(p.regexp[p.pos:], `\E`)
+	for _, r := range quoted {
+		p.tokens = append(p.tokens, literalToken{char: r})
+	}
+	p.pos = min(p.pos+len(quoted)+len(`\E`), len(p.regexp))
+}
+
+// parseHexEscape parses the digits of a hex escape whose "\\x" prefix has already been consumed.
+// It accepts exactly two hex digits, as in "\\x41", or a braced code point, as in "\\x{1F600}".
+func (p *parser) parseHexEscape() (rune, error) {
+	rest := p.regexp[p.pos:]
+	if !strings.HasPrefix(rest, "{") {
+		return p.parseHexDigits("hex", 'x', 2)
+	}
+
+	closing := strings.IndexRune(rest, '}')
+	if closing < 0 {
+		return 0, errors.New("unclosed hex escape: \\x{")
+	}
+	digits := rest[1:closing]
+	escape := "\\x{" + digits + "}"
+	p.pos += closing + 1
+	if len(digits) == 0 || len(digits) > 8 {
+		return 0, fmt.Errorf("invalid hex escape: %s", escape)
+	}
+	return codePoint(digits, "hex", escape)
+}
+
+// parseHexDigits reads exactly n hex digits following an escape letter such as 'x' or 'u',
+// whose backslash and letter have already been consumed, and returns the code point they denote.
+// The kind names the escape in error messages.
+func (p *parser) parseHexDigits(kind string, letter rune, n int) (rune, error) {
+	rest := p.regexp[p.pos:]
+	if len(rest) < n {
+		return 0, fmt.Errorf("invalid %s escape: \\%c%s", kind, letter, rest)
+	}
+
+	digits := rest[:n]
+	p.pos += n
+	return codePoint(digits, kind, fmt.Sprintf("\\%c%s", letter, digits))
+}
+
+// codePoint converts hex digits into a rune, reporting the whole escape in the error if they are
+// not valid hex or exceed the Unicode range.
+func codePoint(digits, kind, escape string) (rune, error) {
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || code > unicode.MaxRune {
+		return 0, fmt.Errorf("invalid %s escape: %s", kind, escape)
+	}
+	return rune(code), nil
+}
+
 // parsePositiveSet parses a positive set from the input string. It expects the input to start with '[' and contain a closing ']'.
-// It reads runes from the input string and appends them to the setItems slice. If a range (e.g., 'a-z') is detected, it handles it appropriately.
 // If the input string ends unexpectedly or if the set is not properly closed, it returns an error.
 func (p *parser) parsePositiveSet() error {
 	if p.next() != '[' {
@@ -148,46 +309,16 @@ func (p *parser) parsePositiveSet() error {
 		return errors.New("unclosed '[' in positive set")
 	}
 
-	var previousChar rune
-	setItems := make([]rune, 0)
-	for currentChar := p.next(); currentChar != ']'; currentChar = p.next() {
-		if currentChar == EOF {
-			return errors.New("unexpected EOF while parsing positive set")
-		}
-
-		if currentChar == '-' && previousChar != 0 {
-			rangeStart := previousChar
-			rangeEnd := p.next()
-			if rangeEnd == EOF {
-				return errors.New("unexpected EOF while parsing range in positive set")
-			} else if rangeEnd == ']' {
-				setItems = append(setItems, previousChar, '-')
-				break
-			}
-
-			if rangeStart > rangeEnd {
-				return fmt.Errorf("invalid range: %c-%c", rangeStart, rangeEnd)
-			}
-
-			for ch := rangeStart; ch <= rangeEnd; ch++ {
-				setItems = append(setItems, ch)
-			}
-			previousChar = 0
-		} else {
-			setItems = append(setItems, currentChar)
-			previousChar = currentChar
-		}
+	ranges, err := p.parseSetItems("positive")
+	if err != nil {
+		return err
 	}
 
-	if len(setItems) == 0 {
-		return errors.New("empty positive set")
-	}
-	p.tokens = append(p.tokens, positiveSetToken{setItems})
+	p.tokens = append(p.tokens, positiveSetToken{ranges: ranges})
 	return nil
 }
 
 // parseNegativeSet parses a negative set from the input string. It expects the input to start with '[^' and contain a closing ']'.
-// It reads runes from the input string and appends them to the setItems slice. If a range (e.g., 'a-z') is detected, it handles it appropriately.
 // If the input string ends unexpectedly or if the set is not properly closed, it returns an error.
 func (p *parser) parseNegativeSet() error {
 	if p.next() != '[' || p.next() != '^' {
@@ -196,80 +327,208 @@ func (p *parser) parseNegativeSet() error {
 		return errors.New("unclosed '[' in negative set")
 	}
 
-	var previousChar rune
-	setItems := make([]rune, 0)
-	for currentChar := p.next(); currentChar != ']'; currentChar = p.next() {
-		if currentChar == EOF {
-			return errors.New("unexpected EOF while parsing negative set")
+	ranges, err := p.parseSetItems("negative")
+	if err != nil {
+		return err
+	}
+
+	p.tokens = append(p.tokens, negativeSetToken{ranges: ranges})
+	return nil
+}
+
+// parseSetItems reads the items of a set up to and including the closing ']' and returns them as inclusive ranges
+// of runes, in the order they appear; a single rune 'c' is the range c-c. Ranges like 'a-z' are kept as they are
+// rather than expanded, so even the widest range costs no more than a single rune. Escaped runes may serve as
+// either end of a range. As in POSIX, a ']' right after the opening '[' or '[^' is a member of the set rather than
+// its end, so a set is never empty. The kind of the set, "positive" or "negative", is used in error messages.
+func (p *parser) parseSetItems(kind string) ([][2]rune, error) {
+	var ranges [][2]rune
+	canStartRange := false
+	for first := true; ; first = false {
+		currentChar, escaped, err := p.nextSetChar(kind)
+		if err != nil {
+			return nil, err
+		} else if currentChar == ']' && !escaped && !first {
+			break
 		}
 
-		if currentChar == '-' && previousChar != 0 {
-			rangeStart := previousChar
-			rangeEnd := p.next()
-			if rangeEnd == EOF {
-				return errors.New("unexpected EOF while parsing range in negative set")
-			} else if rangeEnd == ']' {
-				setItems = append(setItems, previousChar, '-')
-				break
+		if currentChar == '[' && !escaped && p.atPosixClass() {
+			classRanges, err := p.parsePosixClass()
+			if err != nil {
+				return nil, err
 			}
+			ranges = append(ranges, classRanges...)
+			canStartRange = false
+			continue
+		}
 
-			if rangeStart > rangeEnd {
-				return fmt.Errorf("invalid range: %c-%c", rangeStart, rangeEnd)
+		if currentChar == '-' && !escaped && canStartRange {
+			rangeEnd, escaped, err := p.nextSetChar(kind)
+			if err != nil {
+				return nil, err
+			} else if rangeEnd == ']' && !escaped {
+				ranges = append(ranges, [2]rune{'-', '-'})
+				break
 			}
 
-			for ch := rangeStart; ch <= rangeEnd; ch++ {
-				setItems = append(setItems, ch)
+			// The range start has already been added as a single rune; widen it to the end of the range.
+			last := &ranges[len(ranges)-1]
+			if last[0] > rangeEnd {
+				return nil, fmt.Errorf("invalid range: %c-%c", last[0], rangeEnd)
 			}
-
-			previousChar = 0
+			last[1] = rangeEnd
+			canStartRange = false
 		} else {
-			setItems = append(setItems, currentChar)
-			previousChar = currentChar
+			ranges = append(ranges, [2]rune{currentChar, currentChar})
+			canStartRange = true
 		}
 	}
+	return ranges, nil
+}
 
-	if len(setItems) == 0 {
-		return errors.New("empty negative set")
+// nextSetChar reads the next rune of a set, resolving an escape sequence if there is one.
+// Inside a set, hex and Unicode escapes denote their code point, and a backslash before punctuation,
+// as in "\\]" or "\\-", makes it an ordinary member of the set. It reports whether the rune was escaped.
+func (p *parser) nextSetChar(kind string) (rune, bool, error) {
+	r := p.next()
+	if r == EOF {
+		return 0, false, fmt.Errorf("unexpected EOF while parsing %s set", kind)
+	} else if r != '\\' {
+		return r, false, nil
 	}
 
-	p.tokens = append(p.tokens, negativeSetToken{setItems})
-	return nil
+	var err error
+	switch escaped := p.next(); {
+	case escaped == EOF:
+		return 0, false, fmt.Errorf("unexpected EOF while parsing %s set", kind)
+	case escaped == 'x':
+		r, err = p.parseHexEscape()
+	case escaped == 'u':
+		r, err = p.parseHexDigits("unicode", 'u', 4)
+	case escaped == 'U':
+		r, err = p.parseHexDigits("unicode", 'U', 8)
+	case isWordChar(escaped):
+		err = fmt.Errorf("unsupported escape in %s set: \\%c", kind, escaped)
+	default:
+		r = escaped
+	}
+	return r, true, err
+}
+
+// posixClasses maps the names of POSIX bracket classes to the ASCII ranges they cover.
+var posixClasses = map[string][][2]rune{
+	"alpha": {{'a', 'z'}, {'A', 'Z'}},
+	"digit": {{'0', '9'}},
+	"alnum": {{'a', 'z'}, {'A', 'Z'}, {'0', '9'}},
+	"space": {{' ', ' '}, {'\t', '\r'}},
+	"upper": {{'A', 'Z'}},
+	"lower": {{'a', 'z'}},
+	"punct": {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+}
+
+// atPosixClass reports whether the input at the current position, just after a '[' inside a set,
+// starts a POSIX bracket class such as "[:digit:]".
+func (p *parser) atPosixClass() bool {
+	rest := p.regexp[p.pos:]
+	return strings.HasPrefix(rest, ":") && strings.Contains(rest[1:], ":]")
+}
+
+// parsePosixClass parses a POSIX bracket class like "[:alpha:]" whose opening '[' has already been consumed.
+// A '^' before the name, as in "[:^digit:]", negates the class, which then covers every rune outside it.
+// It returns the ranges of runes in the class, or an error if the class name is unknown.
+func (p *parser) parsePosixClass() ([][2]rune, error) {
+	rest := p.regexp[p.pos+1:]
+	nameLen := strings.Index(rest, ":]")
+	name := rest[:nameLen]
+	p.pos += 1 + nameLen + len(":]")
+
+	negated := strings.HasPrefix(name, "^")
+	ranges, ok := posixClasses[strings.TrimPrefix(name, "^")]
+	if !ok {
+		return nil, fmt.Errorf("unknown POSIX class: [:%s:]", name)
+	} else if negated {
+		return complementRanges(ranges), nil
+	}
+	return ranges, nil
+}
+
+// complementRanges returns the inclusive ranges of the runes, up to unicode.MaxRune, that none of ranges covers,
+// in ascending order.
+func complementRanges(ranges [][2]rune) [][2]rune {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b [2]rune) int { return int(a[0] - b[0]) })
+
+	var complement [][2]rune
+	next := rune(0)
+	for _, r := range sorted {
+		if r[0] > next {
+			complement = append(complement, [2]rune{next, r[0] - 1})
+		}
+		next = max(next, r[1]+1)
+	}
+	if next <= unicode.MaxRune {
+		complement = append(complement, [2]rune{next, unicode.MaxRune})
+	}
+	return complement
 }
 
 // parseBeginningOfString parses the beginning of string token '^' from the input string.
+// As in POSIX basic regular expressions, '^' is an anchor only at the start of the pattern, a group or
+// an alternative, and anywhere else it is a literal, so "a^b" matches the text "a^b". In multiline mode,
+// where a line may start in the middle of the pattern, as in "a\n^b", it is always an anchor.
 func (p *parser) parseBeginningOfString() error {
 	if p.next() != '^' {
 		return errors.New("expected '^' at the beginning of string")
+	} else if !p.multiline && p.hasOperand() {
+		p.tokens = append(p.tokens, literalToken{char: '^'})
+		return nil
 	}
 
-	token := beginningOfStringToken{}
+	token := beginningOfStringToken{multiline: p.multiline}
 	p.tokens = append(p.tokens, token)
 	return nil
 }
 
 // parseEndOfString parses the end of string token '$' from the input string.
+// As with '^', '$' is an anchor only at the end of the pattern, a group or an alternative, or anywhere
+// in multiline mode, and a literal elsewhere, so "a$b" matches the text "a$b".
 func (p *parser) parseEndOfString() error {
 	if p.next() != '$' {
 		return errors.New("expected '$' at the end of string")
+	} else if next, _ := p.peek(); !p.multiline && next != EOF && next != ')' && next != '|' {
+		p.tokens = append(p.tokens, literalToken{char: '$'})
+		return nil
 	}
 
-	token := endOfStringToken{}
+	token := endOfStringToken{multiline: p.multiline}
 	p.tokens = append(p.tokens, token)
 	return nil
 }
 
+// hasOperand reports whether there is a token a quantifier can apply to.
+// At the start of the pattern, of a group, or of an alternative there is none, and like grep -E,
+// the quantifier character is then taken literally, so "*a" matches the text "*a".
+func (p *parser) hasOperand() bool {
+	if p.inGroup {
+		// The first token is the group under construction.
+		return len(p.tokens) > 1
+	}
+	return len(p.tokens) > 0
+}
+
 // parsePlus parses the one or more quantifier '+' from the input string.
 func (p *parser) parsePlus() error {
 	if p.next() != '+' {
 		return errors.New("expected '+' after character")
-	} else if len(p.tokens) == 0 {
-		return errors.New("no character to apply '+' to")
+	} else if !p.hasOperand() {
+		p.tokens = append(p.tokens, literalToken{char: '+'})
+		return nil
 	}
 
 	lastToken := p.tokens[len(p.tokens)-1]
 	p.tokens = p.tokens[:len(p.tokens)-1]
-	token := plusToken{payload: lastToken}
-	p.tokens = append(p.tokens, token)
+	lazy := p.parseLazy()
+	p.tokens = append(p.tokens, p.parsePossessive(plusToken{payload: lastToken, lazy: lazy}, lazy))
 	return nil
 }
 
@@ -277,14 +536,15 @@ func (p *parser) parsePlus() error {
 func (p *parser) parseStar() error {
 	if p.next() != '*' {
 		return errors.New("expected '*' after character")
-	} else if len(p.tokens) == 0 {
-		return errors.New("no character to apply '*' to")
+	} else if !p.hasOperand() {
+		p.tokens = append(p.tokens, literalToken{char: '*'})
+		return nil
 	}
 
 	lastToken := p.tokens[len(p.tokens)-1]
 	p.tokens = p.tokens[:len(p.tokens)-1]
-	token := starToken{payload: lastToken}
-	p.tokens = append(p.tokens, token)
+	lazy := p.parseLazy()
+	p.tokens = append(p.tokens, p.parsePossessive(starToken{payload: lastToken, lazy: lazy}, lazy))
 	return nil
 }
 
@@ -292,24 +552,145 @@ func (p *parser) parseStar() error {
 func (p *parser) parseOptional() error {
 	if p.next() != '?' {
 		return errors.New("expected '?' after character")
-	} else if len(p.tokens) == 0 {
-		return errors.New("no character to apply '?' to")
+	} else if !p.hasOperand() {
+		p.tokens = append(p.tokens, literalToken{char: '?'})
+		return nil
 	}
 
 	lastToken := p.tokens[len(p.tokens)-1]
 	p.tokens = p.tokens[:len(p.tokens)-1]
-	token := optionalToken{payload: lastToken}
-	p.tokens = append(p.tokens, token)
+	lazy := p.parseLazy()
+	p.tokens = append(p.tokens, p.parsePossessive(optionalToken{payload: lastToken, lazy: lazy}, lazy))
+	return nil
+}
+
+// parseLazy consumes the '?' that makes the quantifier just parsed lazy, if there is one, and reports whether it did.
+func (p *parser) parseLazy() bool {
+	if !strings.HasPrefix(p.regexp[p.pos:], "?") {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// parsePossessive consumes the '+' that makes the quantifier token just parsed possessive, if there is one,
+// and returns the token to append. A lazy quantifier cannot also be possessive, so after "x+?" a '+' is left
+// to quantify the lazy quantifier as usual.
+func (p *parser) parsePossessive(token Token, lazy bool) Token {
+	if lazy || !strings.HasPrefix(p.regexp[p.pos:], "+") {
+		return token
+	}
+	p.pos++
+	return possessiveToken{payload: token}
+}
+
+// maxRepeat is the largest count allowed in a bounded repetition, which is expanded into that many copies.
+const maxRepeat = 1000
+
+// maxSize is the largest number of states the NFA of a pattern may have once its repetitions are expanded.
+// Nested repetitions multiply, so "(?:a{1000}){1000}" would need two million states; like regexp/syntax,
+// which limits the size of the program it compiles, the parser rejects such a pattern instead.
+const maxSize = 1 << 16
+
+// tokensSize estimates the number of states in the NFA of tokens, stopping just above maxSize.
+func tokensSize(tokens []Token) int {
+	size := 0
+	for _, token := range tokens {
+		size = min(size+tokenSize(token), maxSize+1)
+	}
+	return size
+}
+
+// tokenSize estimates the number of states in the NFA of a token: a start and an end state around its operands,
+// each copied as many times as a bounded repetition expands it. It stops just above maxSize.
+func tokenSize(token Token) int {
+	if t, ok := token.(repeatToken); ok {
+		copies := t.max
+		if copies == -1 {
+			copies = max(t.min, 1)
+		}
+		return min(2+tokenSize(t.payload)*copies, maxSize+1)
+	}
+
+	size := 2
+	for _, tokens := range token.Sub() {
+		size = min(size+tokensSize(tokens), maxSize+1)
+	}
+	return size
+}
+
+// parseBraceQuantifier parses a bounded repetition like "{2}", "{2,}" or "{2,4}" from the input string,
+// followed by an optional '?' that makes it lazy. A '{' that does not start a well-formed repetition,
+// or has nothing to repeat, is taken literally.
+func (p *parser) parseBraceQuantifier() error {
+	open := p.pos
+	if p.next() != '{' {
+		return errors.New("expected '{' after character")
+	}
+
+	min, max, ok := p.parseRepeatBounds()
+	if !ok || !p.hasOperand() {
+		p.pos = open + len("{")
+		p.tokens = append(p.tokens, literalToken{char: '{'})
+		return nil
+	} else if min > maxRepeat || max > maxRepeat || max != -1 && min > max {
+		return fmt.Errorf("invalid repeat count: %s", p.regexp[open:p.pos])
+	}
+
+	lastToken := p.tokens[len(p.tokens)-1]
+	p.tokens = p.tokens[:len(p.tokens)-1]
+	lazy := p.parseLazy()
+	p.tokens = append(p.tokens, p.parsePossessive(repeatToken{payload: lastToken, min: min, max: max, lazy: lazy}, lazy))
 	return nil
 }
 
+// parseRepeatBounds parses the bounds of a repetition up to and including the closing '}', just after the '{'.
+// The maximum is -1 if there is none, as in "{2,}". It reports false if the bounds are not well-formed.
+func (p *parser) parseRepeatBounds() (int, int, bool) {
+	min, ok := p.parseRepeatCount()
+	if !ok {
+		return 0, 0, false
+	}
+
+	max := min
+	if strings.HasPrefix(p.regexp[p.pos:], ",") {
+		p.pos++
+		if max, ok = p.parseRepeatCount(); !ok {
+			max = -1
+		}
+	}
+
+	if p.next() != '}' {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+// parseRepeatCount parses the decimal digits of a repeat count, reporting false if there are none.
+// A count too large to represent is returned as maxRepeat+1, to be rejected by the caller.
+func (p *parser) parseRepeatCount() (int, bool) {
+	start := p.pos
+	for p.pos < len(p.regexp) && '0' <= p.regexp[p.pos] && p.regexp[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return 0, false
+	}
+
+	count, err := strconv.Atoi(p.regexp[start:p.pos])
+	if err != nil || count > maxRepeat {
+		return maxRepeat + 1, true
+	}
+	return count, true
+}
+
 // parseWildcard parses the wildcard '.' from the input string.
 func (p *parser) parseWildcard() error {
 	if p.next() != '.' {
 		return errors.New("expected '.'")
 	}
 
-	token := wildcardToken{}
+	token := wildcardToken{dotAll: p.dotAll}
 	p.tokens = append(p.tokens, token)
 	return nil
 }
@@ -318,23 +699,174 @@ func (p *parser) parseWildcard() error {
 // It expects the input to start with '(' and will return an error if it does not.
 // The method creates a new parser instance to parse the group and appends the parsed tokens to the current parser's token list.
 func (p *parser) parseGroup() error {
+	open := p.pos
 	if p.next() != '(' {
 		return errors.New("expected '(' at the beginning of group")
 	}
 
+	group := groupToken{payload: [][]Token{}}
+	prefix := groupPrefix(p.regexp[p.pos:])
+	condition := 0
+	if prefix != "" {
+		p.pos += len(prefix)
+	} else if strings.HasPrefix(p.regexp[p.pos:], "?(") {
+		var err error
+		if condition, err = p.parseCondition(); err != nil {
+			return err
+		}
+		prefix = "?("
+	} else if rest := p.regexp[p.pos:]; strings.HasPrefix(rest, "?P<") || strings.HasPrefix(rest, "?<") {
+		name, err := p.parseGroupName()
+		if err != nil {
+			return err
+		}
+		p.numGroups++
+		p.names = append(p.names, name)
+		group.index, group.name = p.numGroups, name
+		group.capturing = true
+	} else if strings.HasPrefix(p.regexp[p.pos:], "?") {
+		return p.parseFlags()
+	} else {
+		p.numGroups++
+		p.names = append(p.names, "")
+		group.index = p.numGroups
+		group.capturing = true
+	}
+
 	groupParser := parser{
-		regexp: p.regexp,
-		pos:    p.pos,
-		tokens: []token{groupToken{payload: [][]token{}}},
+		regexp:    p.regexp,
+		pos:       p.pos,
+		tokens:    []Token{group},
+		inGroup:   true,
+		multiline: p.multiline,
+		dotAll:    p.dotAll,
+		unicode:   p.unicode,
+		numGroups: p.numGroups,
+		names:     p.names,
 	}
 
 	err := groupParser.parse()
 	if err != nil {
 		return err
+	} else if !groupParser.done {
+		return &SyntaxError{Msg: "missing closing ')' for '('", Pattern: p.regexp, Pos: open}
 	}
 
 	p.pos = groupParser.pos
-	p.tokens = append(p.tokens, groupParser.tokens...)
+	p.numGroups = groupParser.numGroups
+	p.names = groupParser.names
+	if prefix == "" || prefix == "?:" {
+		p.tokens = append(p.tokens, groupParser.tokens...)
+		return nil
+	}
+
+	// The closing ')' leaves the finished group as the only token.
+	payload := groupParser.tokens[0].(groupToken).payload
+	negated := strings.HasSuffix(prefix, "!")
+	switch prefix {
+	case "?(":
+		if len(payload) > 2 {
+			return &SyntaxError{Msg: "more than two alternatives in conditional group", Pattern: p.regexp, Pos: open}
+		}
+		p.tokens = append(p.tokens, conditionalToken{group: condition, payload: payload})
+	case "?>":
+		p.tokens = append(p.tokens, atomicGroupToken{payload: payload})
+	case "?=", "?!":
+		p.tokens = append(p.tokens, lookaheadToken{payload: payload, negated: negated})
+	default:
+		width, ok := fixedWidth(groupToken{payload: payload})
+		if !ok {
+			return &SyntaxError{Msg: "lookbehind is not of fixed length", Pattern: p.regexp, Pos: open}
+		}
+		p.tokens = append(p.tokens, lookbehindToken{payload: payload, negated: negated, width: width})
+	}
+	return nil
+}
+
+// parseCondition parses the "?(N)" that starts a conditional group after its '(' and returns the group number N,
+// or an error if N is not the number of a capturing group opened before.
+func (p *parser) parseCondition() (int, error) {
+	rest := p.regexp[p.pos+len("?("):]
+	end := strings.IndexByte(rest, ')')
+	if end < 0 {
+		return 0, errors.New("unclosed condition: (?(")
+	}
+
+	group, err := strconv.Atoi(rest[:end])
+	if err != nil || group < 1 || group > p.numGroups {
+		return 0, fmt.Errorf("invalid condition: (?(%s)", rest[:end])
+	}
+	p.pos += len("?(") + end + len(")")
+	return group, nil
+}
+
+// parseGroupName parses the "?P<name>" or "?<name>" that starts a named capturing group after its '(' and returns
+// the name. A name is a letter or underscore followed by letters, digits and underscores, and no two groups of
+// a pattern may share one.
+func (p *parser) parseGroupName() (string, error) {
+	open := "?<"
+	if strings.HasPrefix(p.regexp[p.pos:], "?P<") {
+		open = "?P<"
+	}
+	rest := p.regexp[p.pos+len(open):]
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", fmt.Errorf("invalid named capture: (%s%s", open, rest)
+	} else if !isGroupName(rest[:end]) {
+		return "", fmt.Errorf("invalid named capture: (%s%s", open, rest[:end+1])
+	}
+
+	name := rest[:end]
+	if slices.Contains(p.names, name) {
+		return "", fmt.Errorf("duplicate capture group name: %s", name)
+	}
+	p.pos += len(open) + end + len(">")
+	return name, nil
+}
+
+// isGroupName reports whether name is a valid name for a capturing group.
+func isGroupName(name string) bool {
+	for i, r := range name {
+		if !isWordChar(r) || i == 0 && isDigit(r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// groupPrefix returns the characters after '(' that start a special group at the beginning of s:
+// "?:" for a non-capturing group, "?>" for an atomic group, or a lookaround assertion like "?=" or "?<!".
+// It returns an empty string if there are none.
+func groupPrefix(s string) string {
+	for _, prefix := range []string{"?:", "?>", "?=", "?!", "?<=", "?<!"} {
+		if strings.HasPrefix(s, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// parseFlags parses an inline flag group like "(?m)" whose opening '(' has already been consumed.
+// The flags apply to the rest of the enclosing group, or to the rest of the pattern at the top level.
+func (p *parser) parseFlags() error {
+	if p.next() != '?' {
+		return errors.New("expected '?' at the beginning of flags")
+	}
+
+	for flag := p.next(); flag != ')'; flag = p.next() {
+		switch flag {
+		case EOF:
+			return errors.New("unexpected EOF while parsing flags")
+		case 'm':
+			p.multiline = true
+		case 's':
+			p.dotAll = true
+		case 'u':
+			p.unicode = true
+		default:
+			return fmt.Errorf("unsupported flag: %c", flag)
+		}
+	}
 	return nil
 }
 
@@ -342,9 +874,14 @@ func (p *parser) parseGroup() error {
 // It expects the input to contain a '|' character and a preceding group of tokens.
 // If the '|' character is not found or if there is no preceding group, it returns an error.
 // The method then appends the tokens following the '|' to the payload of the preceding group token.
+// At the top level, the tokens so far become a branch of their own and the next branch starts empty.
 func (p *parser) parseOr() error {
 	if p.next() != '|' {
 		return errors.New("expected '|'")
+	} else if !p.inGroup {
+		p.branches = append(p.branches, p.tokens)
+		p.tokens = nil
+		return nil
 	} else if _, ok := p.tokens[0].(groupToken); !ok {
 		return errors.New("expected group before '|'")
 	}
@@ -352,14 +889,17 @@ func (p *parser) parseOr() error {
 	previousTokens := p.tokens[1:]
 	groupToken := p.tokens[0].(groupToken)
 	groupToken.payload = append(groupToken.payload, previousTokens)
-	p.tokens = []token{groupToken}
+	p.tokens = []Token{groupToken}
 	return nil
 }
 
 // parseClosingGroup parses the closing ')' character from the input string.
 func (p *parser) parseClosingGroup() error {
+	closing := p.pos
 	if p.next() != ')' {
 		return errors.New("expected ')'")
+	} else if !p.inGroup {
+		return &SyntaxError{Msg: "unmatched ')'", Pattern: p.regexp, Pos: closing}
 	} else if _, ok := p.tokens[0].(groupToken); !ok {
 		return errors.New("expected group before ')'")
 	}
@@ -367,18 +907,177 @@ func (p *parser) parseClosingGroup() error {
 	previousTokens := p.tokens[1:]
 	groupToken := p.tokens[0].(groupToken)
 	groupToken.payload = append(groupToken.payload, previousTokens)
-	p.tokens = []token{groupToken}
+	p.tokens = []Token{groupToken}
 	p.done = true
 	return nil
 }
 
-// token represents a regular expression token.
-type token interface {
+// Op identifies the kind of a parsed Token.
+type Op int
+
+const (
+	OpLiteral           Op = iota // a single rune, like 'a'
+	OpDigit                       // '\d'
+	OpWord                        // '\w'
+	OpPositiveSet                 // '[abc]'
+	OpNegativeSet                 // '[^abc]'
+	OpBeginningOfString           // '^'
+	OpEndOfString                 // '$'
+	OpStartOfInput                // '\A'
+	OpEndOfInput                  // '\z'
+	OpWordBoundary                // '\b'
+	OpNonWordBoundary             // '\B'
+	OpPlus                        // one or more, 'x+'
+	OpStar                        // zero or more, 'x*'
+	OpOptional                    // zero or one, 'x?'
+	OpWildcard                    // '.'
+	OpGroup                       // '(abc|def)'
+	OpRepeat                      // bounded repetition, 'x{2,4}'
+	OpProperty                    // Unicode property class, '\p{L}' or '\P{L}'
+	OpPossessive                  // possessive quantifier, 'x++'
+	OpLookahead                   // lookahead assertion, '(?=abc)' or '(?!abc)'
+	OpLookbehind                  // lookbehind assertion, '(?<=abc)' or '(?<!abc)'
+	OpBackreference               // backreference to a capturing group, '\1'
+	OpAtomicGroup                 // atomic group, '(?>abc)'
+	OpConditional                 // conditional group, '(?(1)abc|def)'
+)
+
+var opNames = map[Op]string{
+	OpLiteral:           "literal",
+	OpDigit:             "digit",
+	OpWord:              "word",
+	OpPositiveSet:       "positive set",
+	OpNegativeSet:       "negative set",
+	OpBeginningOfString: "beginning of string",
+	OpEndOfString:       "end of string",
+	OpStartOfInput:      "start of input",
+	OpEndOfInput:        "end of input",
+	OpWordBoundary:      "word boundary",
+	OpNonWordBoundary:   "non-word boundary",
+	OpPlus:              "plus",
+	OpStar:              "star",
+	OpOptional:          "optional",
+	OpWildcard:          "wildcard",
+	OpGroup:             "group",
+	OpRepeat:            "repeat",
+	OpProperty:          "property",
+	OpPossessive:        "possessive",
+	OpLookahead:         "lookahead",
+	OpLookbehind:        "lookbehind",
+	OpBackreference:     "backreference",
+	OpAtomicGroup:       "atomic group",
+	OpConditional:       "conditional",
+}
+
+// String returns a human-readable name for the op.
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return "Op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// Token represents a regular expression token.
+// Outside the package it is a read-only view of the parse result.
+type Token interface {
+	// Op reports the kind of the token.
+	Op() Op
+	// Sub returns the token sequences nested in the token: the single operand of a quantifier,
+	// or each alternative of a group. It returns nil for tokens without operands.
+	Sub() [][]Token
+	// String renders the token in pattern syntax.
+	String() string
+
 	toNfa() *nfa
 }
 
+// leaf provides the Sub method for tokens without operands.
+type leaf struct{}
+
+// Sub returns nil, since a leaf token has no operands.
+func (leaf) Sub() [][]Token { return nil }
+
+// SyntaxError reports a problem in a pattern together with the byte offset in the pattern where it was found.
+type SyntaxError struct {
+	Msg     string
+	Pattern string
+	Pos     int
+}
+
+// Error returns the message followed by the position and the pattern it refers to.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d in %q", e.Msg, e.Pos, e.Pattern)
+}
+
+// Valid reports whether pattern is a valid regular expression, returning nil if it is or the parse error if not.
+// It only parses the pattern, without building an NFA, which makes it cheap enough to check a pattern as it is typed.
+func Valid(pattern string) error {
+	p := parser{regexp: pattern}
+	return p.parse()
+}
+
+// Parse parses a regular expression and returns its tokens without building an NFA.
+func Parse(pattern string) ([]Token, error) {
+	p := parser{regexp: pattern}
+	err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return p.tokens, nil
+}
+
+// metaChars lists the runes that have a special meaning outside of character sets.
+const metaChars = `\.+*?()|[]{}^$`
+
+// quoteRune renders r so that it is parsed back as a literal, escaping metacharacters with a backslash
+// and using a hex escape for runes that are not printable.
+func quoteRune(r rune) string {
+	if strings.ContainsRune(metaChars, r) {
+		return `\` + string(r)
+	} else if !unicode.IsPrint(r) {
+		return fmt.Sprintf(`\x{%X}`, r)
+	}
+	return string(r)
+}
+
+// quoteSetItems renders the ranges of a character set so that they are parsed back as the same set,
+// escaping the runes that are special inside a set and those that are not printable.
+// A range of two runes is written as the two runes, and a longer one with a '-' between its ends.
+func quoteSetItems(ranges [][2]rune) string {
+	var sb strings.Builder
+	for _, r := range ranges {
+		sb.WriteString(quoteSetRune(r[0]))
+		if r[1] == r[0]+1 {
+			sb.WriteString(quoteSetRune(r[1]))
+		} else if r[1] > r[0] {
+			sb.WriteString("-" + quoteSetRune(r[1]))
+		}
+	}
+	return sb.String()
+}
+
+// quoteSetRune renders a single rune of a character set, as described for quoteSetItems.
+func quoteSetRune(r rune) string {
+	if strings.ContainsRune(`\[]^-`, r) {
+		return `\` + string(r)
+	} else if !unicode.IsPrint(r) {
+		return fmt.Sprintf(`\x{%X}`, r)
+	}
+	return string(r)
+}
+
+// joinTokens renders a token sequence in pattern syntax.
+func joinTokens(tokens []Token) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.String())
+	}
+	return sb.String()
+}
+
 // literalToken represents a character token.
 type literalToken struct {
+	leaf
 	char rune
 }
 
@@ -390,151 +1089,764 @@ func (t literalToken) toNfa() *nfa {
 	return &nfa{start, end}
 }
 
+// Op returns OpLiteral.
+func (t literalToken) Op() Op { return OpLiteral }
+
+// String renders the literal token in pattern syntax.
+func (t literalToken) String() string { return quoteRune(t.char) }
+
 // digitToken represents a digit token.
-type digitToken struct{}
+// It matches the ASCII digits, or in Unicode mode any decimal digit, like the Arabic-Indic '٣'.
+type digitToken struct {
+	leaf
+	unicode bool
+}
 
 // toNfa converts the digit token to an NFA.
+// The class is a predicate on the transition rather than an edge for each rune, so it costs no map to build.
 func (t digitToken) toNfa() *nfa {
-	start := &state{edges: make(map[rune][]*state)}
 	end := &state{isFinal: true}
-	for r := '0'; r <= '9'; r++ {
-		start.edges[r] = []*state{end}
+	accepts := isDigit
+	if t.unicode {
+		accepts = unicode.IsDigit
 	}
-	return &nfa{start, end}
+	return &nfa{&state{anyChar: []*state{end}, accepts: accepts}, end}
+}
+
+// isDigit reports whether r is an ASCII digit.
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
 }
 
+// Op returns OpDigit.
+func (t digitToken) Op() Op { return OpDigit }
+
+// String renders the digit token in pattern syntax.
+func (t digitToken) String() string { return `\d` }
+
 // wordToken represents an alphanumeric character token.
-type wordToken struct{}
+// It matches ASCII letters, digits and '_', or in Unicode mode any letter, number or '_', like 'é'.
+type wordToken struct {
+	leaf
+	unicode bool
+}
 
 // toNfa converts the word token to an NFA.
 func (t wordToken) toNfa() *nfa {
-	start := &state{edges: make(map[rune][]*state)}
 	end := &state{isFinal: true}
-	for r := 'a'; r <= 'z'; r++ {
-		start.edges[r] = []*state{end}
+	accepts := isWordChar
+	if t.unicode {
+		accepts = isUnicodeWordChar
 	}
-	for r := 'A'; r <= 'Z'; r++ {
-		start.edges[r] = []*state{end}
-	}
-	for r := '0'; r <= '9'; r++ {
-		start.edges[r] = []*state{end}
-	}
-	start.edges['_'] = []*state{end}
-	return &nfa{start, end}
+	return &nfa{&state{anyChar: []*state{end}, accepts: accepts}, end}
 }
 
-// positiveSetToken represents a positive character set token.
-type positiveSetToken struct {
-	setItems []rune
+// isUnicodeWordChar reports whether r is a letter, a number or '_' in any script.
+func isUnicodeWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
 }
 
-// toNfa converts the positive set token to an NFA.
-func (t positiveSetToken) toNfa() *nfa {
-	start := &state{edges: make(map[rune][]*state)}
-	end := &state{isFinal: true}
-	for _, r := range t.setItems {
-		start.edges[r] = []*state{end}
-	}
-	return &nfa{start, end}
-}
+// Op returns OpWord.
+func (t wordToken) Op() Op { return OpWord }
 
-// negativeSetToken represents a negative character set token.
-type negativeSetToken struct {
-	setItems []rune
+// String renders the word token in pattern syntax.
+func (t wordToken) String() string { return `\w` }
+
+// propertyToken represents a Unicode property class like '\\p{L}', or its negation like '\\P{L}'.
+// The name is a Unicode general category, like "L" or "Lu", or a script, like "Greek".
+type propertyToken struct {
+	leaf
+	name    string
+	negated bool
 }
 
-// toNfa converts the negative set token to an NFA.
-func (t negativeSetToken) toNfa() *nfa {
-	start := &state{edges: make(map[rune][]*state)}
+// toNfa converts the property token to an NFA.
+func (t propertyToken) toNfa() *nfa {
+	table := propertyTable(t.name)
 	end := &state{isFinal: true}
-	deadEnd := &state{}
-	for _, r := range t.setItems {
-		start.edges[r] = []*state{deadEnd}
-	}
-	start.anyChar = []*state{end}
+	start := &state{anyChar: []*state{end}, accepts: func(r rune) bool { return unicode.Is(table, r) != t.negated }}
 	return &nfa{start, end}
 }
 
-// beginningOfStringToken represents the beginning of string token.
-type beginningOfStringToken struct{}
+// Op returns OpProperty.
+func (t propertyToken) Op() Op { return OpProperty }
+
+// String renders the property token in pattern syntax.
+func (t propertyToken) String() string {
+	letter := "p"
+	if t.negated {
+		letter = "P"
+	}
+	return `\` + letter + "{" + t.name + "}"
+}
+
+// propertyTable returns the range table of the Unicode general category or script with the given name,
+// or nil if there is none.
+func propertyTable(name string) *unicode.RangeTable {
+	if table, ok := unicode.Categories[name]; ok {
+		return table
+	}
+	return unicode.Scripts[name]
+}
+
+// inRanges reports whether r lies in one of the inclusive ranges.
+func inRanges(ranges [][2]rune, r rune) bool {
+	for _, rng := range ranges {
+		if rng[0] <= r && r <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// positiveSetToken represents a positive character set token.
+// The set is a list of inclusive ranges of runes.
+type positiveSetToken struct {
+	leaf
+	ranges [][2]rune
+}
+
+// toNfa converts the positive set token to an NFA.
+func (t positiveSetToken) toNfa() *nfa {
+	end := &state{isFinal: true}
+	start := &state{anyChar: []*state{end}, accepts: func(r rune) bool { return inRanges(t.ranges, r) }}
+	return &nfa{start, end}
+}
+
+// Op returns OpPositiveSet.
+func (t positiveSetToken) Op() Op { return OpPositiveSet }
+
+// String renders the positive set token in pattern syntax.
+func (t positiveSetToken) String() string { return "[" + quoteSetItems(t.ranges) + "]" }
+
+// negativeSetToken represents a negative character set token.
+// It matches any rune of the input that is not in the set, including newlines and other control characters.
+type negativeSetToken struct {
+	leaf
+	ranges [][2]rune
+}
+
+// toNfa converts the negative set token to an NFA.
+func (t negativeSetToken) toNfa() *nfa {
+	end := &state{isFinal: true}
+	start := &state{anyChar: []*state{end}, accepts: func(r rune) bool { return !inRanges(t.ranges, r) }}
+	return &nfa{start, end}
+}
+
+// Op returns OpNegativeSet.
+func (t negativeSetToken) Op() Op { return OpNegativeSet }
+
+// String renders the negative set token in pattern syntax.
+func (t negativeSetToken) String() string { return "[^" + quoteSetItems(t.ranges) + "]" }
+
+// beginningOfStringToken represents the beginning of string token '^'.
+// In multiline mode it also matches right after a newline.
+type beginningOfStringToken struct {
+	leaf
+	multiline bool
+}
 
 // toNfa converts the beginning of string token to an NFA.
 func (t beginningOfStringToken) toNfa() *nfa {
-	start := &state{control: make(map[rune][]*state)}
 	end := &state{isFinal: true}
-	start.control[BOS] = []*state{end}
+	start := &state{assert: isStartOfInput, epsilon: []*state{end}}
+	if t.multiline {
+		start.assert = isLineStart
+	}
 	return &nfa{start, end}
 }
 
-// endOfStringToken represents the end of string token.
-type endOfStringToken struct{}
+// Op returns OpBeginningOfString.
+func (t beginningOfStringToken) Op() Op { return OpBeginningOfString }
+
+// String renders the beginning of string token in pattern syntax.
+func (t beginningOfStringToken) String() string { return "^" }
+
+// endOfStringToken represents the end of string token '$'.
+// In multiline mode it also matches right before a newline.
+type endOfStringToken struct {
+	leaf
+	multiline bool
+}
 
 // toNfa converts the end of string token to an NFA.
 func (t endOfStringToken) toNfa() *nfa {
-	start := &state{control: make(map[rune][]*state)}
 	end := &state{isFinal: true}
-	start.control[EOS] = []*state{end}
+	start := &state{assert: isEndOfInput, epsilon: []*state{end}}
+	if t.multiline {
+		start.assert = isLineEnd
+	}
 	return &nfa{start, end}
 }
 
+// Op returns OpEndOfString.
+func (t endOfStringToken) Op() Op { return OpEndOfString }
+
+// String renders the end of string token in pattern syntax.
+func (t endOfStringToken) String() string { return "$" }
+
+// isStartOfInput reports whether pos directly follows the BOS character.
+// The check is by position rather than by byte, so an input that itself contains the BOS byte is not mistaken
+// for starting there.
+func isStartOfInput(input string, pos int) bool {
+	return pos == bosWidth
+}
+
+// isEndOfInput reports whether pos directly precedes the EOS character.
+// Like isStartOfInput, it compares positions so that an EOS byte inside the input is just another character.
+func isEndOfInput(input string, pos int) bool {
+	return pos == len(input)-eosWidth
+}
+
+// isLineStart reports whether pos is at the start of the input or directly follows a newline.
+func isLineStart(input string, pos int) bool {
+	return isStartOfInput(input, pos) || pos > 0 && input[pos-1] == '\n'
+}
+
+// isLineEnd reports whether pos is at the end of the input or directly precedes a newline.
+func isLineEnd(input string, pos int) bool {
+	return isEndOfInput(input, pos) || pos < len(input) && input[pos] == '\n'
+}
+
+// startOfInputToken represents the absolute start of input anchor '\\A'.
+// Unlike '^', it never matches after a newline, whatever the flags.
+type startOfInputToken struct{ leaf }
+
+// toNfa converts the start of input token to an NFA.
+func (t startOfInputToken) toNfa() *nfa {
+	return beginningOfStringToken{}.toNfa()
+}
+
+// Op returns OpStartOfInput.
+func (t startOfInputToken) Op() Op { return OpStartOfInput }
+
+// String renders the start of input token in pattern syntax.
+func (t startOfInputToken) String() string { return `\A` }
+
+// endOfInputToken represents the absolute end of input anchor '\\z'.
+// Unlike '$', it never matches before a newline, whatever the flags.
+type endOfInputToken struct{ leaf }
+
+// toNfa converts the end of input token to an NFA.
+func (t endOfInputToken) toNfa() *nfa {
+	return endOfStringToken{}.toNfa()
+}
+
+// Op returns OpEndOfInput.
+func (t endOfInputToken) Op() Op { return OpEndOfInput }
+
+// String renders the end of input token in pattern syntax.
+func (t endOfInputToken) String() string { return `\z` }
+
+// wordBoundaryToken represents the word boundary assertion '\\b'.
+type wordBoundaryToken struct{ leaf }
+
+// toNfa converts the word boundary token to an NFA that consumes no input.
+func (t wordBoundaryToken) toNfa() *nfa {
+	end := &state{isFinal: true}
+	start := &state{assert: isWordBoundary, epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpWordBoundary.
+func (t wordBoundaryToken) Op() Op { return OpWordBoundary }
+
+// String renders the word boundary token in pattern syntax.
+func (t wordBoundaryToken) String() string { return `\b` }
+
+// nonWordBoundaryToken represents the non-word boundary assertion '\\B'.
+type nonWordBoundaryToken struct{ leaf }
+
+// toNfa converts the non-word boundary token to an NFA that consumes no input.
+func (t nonWordBoundaryToken) toNfa() *nfa {
+	end := &state{isFinal: true}
+	start := &state{assert: isNonWordBoundary, epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpNonWordBoundary.
+func (t nonWordBoundaryToken) Op() Op { return OpNonWordBoundary }
+
+// String renders the non-word boundary token in pattern syntax.
+func (t nonWordBoundaryToken) String() string { return `\B` }
+
+// isWordChar reports whether r is matched by '\\w'.
+func isWordChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_'
+}
+
+// isWordBoundary reports whether exactly one of the runes before and after pos is a word character.
+// The BOS and EOS sentinels are not word characters, so the edges of a line count as non-word.
+func isWordBoundary(input string, pos int) bool {
+	before, _ := utf8.DecodeLastRuneInString(input[:pos])
+	after, _ := utf8.DecodeRuneInString(input[pos:])
+	return isWordChar(before) != isWordChar(after)
+}
+
+// isNonWordBoundary reports whether the runes before and after pos are both word characters or both not.
+func isNonWordBoundary(input string, pos int) bool {
+	return !isWordBoundary(input, pos)
+}
+
 // plusToken represents an one or more quantifier token.
+// A lazy quantifier, written "x+?", prefers as few repetitions as possible.
 type plusToken struct {
-	payload token
+	payload Token
+	lazy    bool
 }
 
 // toNfa converts the plus token to an NFA.
+// Repeating the payload is preferred over leaving it through the fresh end state, which makes the quantifier greedy;
+// a lazy quantifier prefers leaving.
 func (t plusToken) toNfa() *nfa {
-	nfa := t.payload.toNfa()
-	nfa.end.epsilon = append(nfa.end.epsilon, nfa.start)
-	return nfa
+	inner := t.payload.toNfa()
+	end := &state{isFinal: true}
+	inner.end.epsilon = append(inner.end.epsilon, preferred(t.lazy, inner.start, end)...)
+	inner.end.isFinal = false
+	return &nfa{inner.start, end}
 }
 
+// Op returns OpPlus.
+func (t plusToken) Op() Op { return OpPlus }
+
+// Sub returns the quantified token.
+func (t plusToken) Sub() [][]Token { return [][]Token{{t.payload}} }
+
+// String renders the plus token in pattern syntax.
+func (t plusToken) String() string { return t.payload.String() + "+" + lazySuffix(t.lazy) }
+
 // starToken represents a zero or more quantifier token.
+// A lazy quantifier, written "x*?", prefers as few repetitions as possible.
 type starToken struct {
-	payload token
+	payload Token
+	lazy    bool
 }
 
 // toNfa converts the star token to an NFA.
+// The payload is wrapped in fresh start and end states so that skipping the payload
+// and repeating it never form an epsilon cycle between the same pair of states.
+// As in RE2, a payload that always consumes input returns to the start state to choose again, so after
+// an iteration the search meets the same state it met after the one before. When visitSet skips it,
+// the search leaves the loop rather than trying another iteration first, as "(?:.*?)*1" does in " 11".
+// A payload that can match the empty string repeats through a choice of its own instead, which keeps
+// an empty iteration from closing an epsilon cycle through the start state.
 func (t starToken) toNfa() *nfa {
-	nfa := t.payload.toNfa()
-	nfa.end.epsilon = append(nfa.end.epsilon, nfa.start)
-	nfa.start.epsilon = append(nfa.start.epsilon, nfa.end)
-	return nfa
+	inner := t.payload.toNfa()
+	start := &state{}
+	end := &state{isFinal: true}
+	start.epsilon = preferred(t.lazy, inner.start, end)
+	if inner.nullable() {
+		inner.end.epsilon = append(inner.end.epsilon, preferred(t.lazy, inner.start, end)...)
+	} else {
+		inner.end.epsilon = append(inner.end.epsilon, start)
+	}
+	inner.end.isFinal = false
+	return &nfa{start, end}
 }
 
+// Op returns OpStar.
+func (t starToken) Op() Op { return OpStar }
+
+// Sub returns the quantified token.
+func (t starToken) Sub() [][]Token { return [][]Token{{t.payload}} }
+
+// String renders the star token in pattern syntax.
+func (t starToken) String() string { return t.payload.String() + "*" + lazySuffix(t.lazy) }
+
 // optionalToken represents a zero or one quantifier token.
+// A lazy quantifier, written "x??", prefers skipping the payload.
 type optionalToken struct {
-	payload token
+	payload Token
+	lazy    bool
 }
 
 // toNfa converts the optional token to an NFA.
+// The fresh start state chooses between the payload and skipping it, in the order of preference.
+// Skipping leads to a fresh end state rather than to the end of the payload, so that it does not pass through
+// the state recording the end of a capturing group, which would make "(a)?" look matched when it was skipped.
 func (t optionalToken) toNfa() *nfa {
-	nfa := t.payload.toNfa()
-	nfa.start.epsilon = append(nfa.start.epsilon, nfa.end)
-	return nfa
+	inner := t.payload.toNfa()
+	end := &state{isFinal: true}
+	start := &state{epsilon: preferred(t.lazy, inner.start, end)}
+	inner.end.epsilon = append(inner.end.epsilon, end)
+	inner.end.isFinal = false
+	return &nfa{start, end}
+}
+
+// Op returns OpOptional.
+func (t optionalToken) Op() Op { return OpOptional }
+
+// Sub returns the quantified token.
+func (t optionalToken) Sub() [][]Token { return [][]Token{{t.payload}} }
+
+// String renders the optional token in pattern syntax.
+func (t optionalToken) String() string { return t.payload.String() + "?" + lazySuffix(t.lazy) }
+
+// repeatToken represents a bounded repetition token like "x{2,4}".
+// The payload must match at least min times and at most max times, or any number of times if max is -1.
+// A lazy repetition, written "x{2,4}?", prefers as few repetitions as possible.
+type repeatToken struct {
+	payload Token
+	min     int
+	max     int
+	lazy    bool
+}
+
+// toNfa converts the repeat token to an NFA.
+// An unbounded repetition is expanded as regexp/syntax does: "x{2,}" becomes "xx+" and "x{0,}" becomes "x*",
+// so that it prefers the same matches as those quantifiers even when x can match the empty string.
+// Otherwise the payload is copied min times, followed by max-min nested optional copies, as in "xx(?:x(?:x)?)?"
+// for "x{2,4}". Nesting keeps the optional copies from matching the same repetitions in different ways.
+func (t repeatToken) toNfa() *nfa {
+	if t.max == -1 {
+		if t.min == 0 {
+			return starToken{payload: t.payload, lazy: t.lazy}.toNfa()
+		}
+		tokens := make([]Token, 0, t.min)
+		for range t.min - 1 {
+			tokens = append(tokens, t.payload)
+		}
+		return buildNfa(append(tokens, plusToken{payload: t.payload, lazy: t.lazy}))
+	}
+
+	tokens := make([]Token, 0, t.min+1)
+	for range t.min {
+		tokens = append(tokens, t.payload)
+	}
+	if t.max > t.min {
+		var optional Token = optionalToken{payload: t.payload, lazy: t.lazy}
+		for range t.max - t.min - 1 {
+			nested := groupToken{payload: [][]Token{{t.payload, optional}}}
+			optional = optionalToken{payload: nested, lazy: t.lazy}
+		}
+		tokens = append(tokens, optional)
+	}
+	return buildNfa(tokens)
+}
+
+// Op returns OpRepeat.
+func (t repeatToken) Op() Op { return OpRepeat }
+
+// Sub returns the quantified token.
+func (t repeatToken) Sub() [][]Token { return [][]Token{{t.payload}} }
+
+// String renders the repeat token in pattern syntax.
+func (t repeatToken) String() string {
+	bounds := strconv.Itoa(t.min)
+	if t.max == -1 {
+		bounds += ","
+	} else if t.max != t.min {
+		bounds += "," + strconv.Itoa(t.max)
+	}
+	return t.payload.String() + "{" + bounds + "}" + lazySuffix(t.lazy)
+}
+
+// possessiveToken represents a possessive quantifier, written "x++", "x*+", "x?+" or "x{2,4}+".
+// The quantifier payload matches as the greedy quantifier would, but once it has matched, the search never
+// backtracks into it to try fewer repetitions, so "a++a" never matches.
+type possessiveToken struct {
+	payload Token
+}
+
+// toNfa converts the possessive token to an NFA.
+// The quantifier gets an NFA of its own, which an atomic state searches for the preferred match only;
+// the rest of the pattern then continues from the end of that match alone.
+func (t possessiveToken) toNfa() *nfa {
+	inner := t.payload.toNfa()
+	end := &state{isFinal: true}
+	start := &state{sub: newSubSearch(inner, atomicSearch, false), epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpPossessive.
+func (t possessiveToken) Op() Op { return OpPossessive }
+
+// Sub returns the quantifier made possessive.
+func (t possessiveToken) Sub() [][]Token { return [][]Token{{t.payload}} }
+
+// String renders the possessive quantifier in pattern syntax.
+func (t possessiveToken) String() string { return t.payload.String() + "+" }
+
+// atomicGroupToken represents an atomic group, written "(?>abc)". Once its alternatives have matched,
+// the search never backtracks into them to try another way, so "(?>a+)ab" never matches.
+type atomicGroupToken struct {
+	payload [][]Token
+}
+
+// toNfa converts the atomic group token to an NFA, searched like the payload of a possessive quantifier.
+func (t atomicGroupToken) toNfa() *nfa {
+	inner := groupToken{payload: t.payload}.toNfa()
+	end := &state{isFinal: true}
+	start := &state{sub: newSubSearch(inner, atomicSearch, false), epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpAtomicGroup.
+func (t atomicGroupToken) Op() Op { return OpAtomicGroup }
+
+// Sub returns the alternatives of the group.
+func (t atomicGroupToken) Sub() [][]Token { return t.payload }
+
+// String renders the atomic group token in pattern syntax.
+func (t atomicGroupToken) String() string { return "(?>" + joinAlternatives(t.payload) + ")" }
+
+// conditionalToken represents a conditional group, written "(?(1)abc|def)". It matches its first alternative
+// if the capturing group has matched, and otherwise its second, which may be left out to match the empty string,
+// as in "(?(1)abc)".
+type conditionalToken struct {
+	group   int
+	payload [][]Token
+}
+
+// branches returns the alternatives to match if the group has matched and if it has not.
+func (t conditionalToken) branches() [][]Token {
+	if len(t.payload) == 1 {
+		return [][]Token{t.payload[0], {}}
+	}
+	return t.payload
+}
+
+// toNfa converts the conditional token to an NFA. The start state leads to both alternatives through states
+// that check the group, so only one of them can be followed.
+func (t conditionalToken) toNfa() *nfa {
+	branches := t.branches()
+	ifSet := &state{groupSet: t.group}
+	ifUnset := &state{groupUnset: t.group}
+	end := &state{isFinal: true}
+	for i, from := range []*state{ifSet, ifUnset} {
+		branch := groupToken{payload: branches[i : i+1]}.toNfa()
+		from.epsilon = []*state{branch.start}
+		branch.end.epsilon = append(branch.end.epsilon, end)
+		branch.end.isFinal = false
+	}
+	return &nfa{&state{epsilon: []*state{ifSet, ifUnset}}, end}
+}
+
+// Op returns OpConditional.
+func (t conditionalToken) Op() Op { return OpConditional }
+
+// Sub returns the alternatives of the group.
+func (t conditionalToken) Sub() [][]Token { return t.payload }
+
+// String renders the conditional token in pattern syntax.
+func (t conditionalToken) String() string {
+	return "(?(" + strconv.Itoa(t.group) + ")" + joinAlternatives(t.payload) + ")"
+}
+
+// lookaheadToken represents a lookahead assertion, written "(?=abc)", which matches the empty string
+// where its alternatives match the input ahead without consuming it. A negated assertion, written "(?!abc)",
+// matches where they do not. Capturing groups inside a positive assertion keep what they matched.
+type lookaheadToken struct {
+	payload [][]Token
+	negated bool
+}
+
+// toNfa converts the lookahead token to an NFA.
+// The alternatives get an NFA of their own, which a state searches before moving on from the same position.
+func (t lookaheadToken) toNfa() *nfa {
+	inner := groupToken{payload: t.payload}.toNfa()
+	end := &state{isFinal: true}
+	start := &state{sub: newSubSearch(inner, lookahead, t.negated), epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpLookahead.
+func (t lookaheadToken) Op() Op { return OpLookahead }
+
+// Sub returns the alternatives of the assertion.
+func (t lookaheadToken) Sub() [][]Token { return t.payload }
+
+// String renders the lookahead token in pattern syntax.
+func (t lookaheadToken) String() string {
+	open := "(?="
+	if t.negated {
+		open = "(?!"
+	}
+	return open + joinAlternatives(t.payload) + ")"
+}
+
+// lookbehindToken represents a lookbehind assertion, written "(?<=abc)", which matches the empty string
+// where its alternatives match the input just behind. A negated assertion, written "(?<!abc)", matches where
+// they do not. The alternatives must all match the same number of runes, which is width.
+type lookbehindToken struct {
+	payload [][]Token
+	negated bool
+	width   int
+}
+
+// toNfa converts the lookbehind token to an NFA.
+// The alternatives get an NFA of their own, which a state searches from width runes back.
+func (t lookbehindToken) toNfa() *nfa {
+	inner := groupToken{payload: t.payload}.toNfa()
+	end := &state{isFinal: true}
+	sub := newSubSearch(inner, lookbehind, t.negated)
+	sub.width = t.width
+	start := &state{sub: sub, epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpLookbehind.
+func (t lookbehindToken) Op() Op { return OpLookbehind }
+
+// Sub returns the alternatives of the assertion.
+func (t lookbehindToken) Sub() [][]Token { return t.payload }
+
+// String renders the lookbehind token in pattern syntax.
+func (t lookbehindToken) String() string {
+	open := "(?<="
+	if t.negated {
+		open = "(?<!"
+	}
+	return open + joinAlternatives(t.payload) + ")"
+}
+
+// fixedWidth returns the number of runes that every match of the token consumes,
+// or false if matches of different lengths are possible.
+func fixedWidth(t Token) (int, bool) {
+	switch t := t.(type) {
+	case plusToken, starToken, optionalToken:
+		return 0, false
+	case repeatToken:
+		width, ok := fixedWidth(t.payload)
+		return width * t.min, ok && (t.min == t.max || width == 0)
+	case possessiveToken:
+		return fixedWidth(t.payload)
+	case atomicGroupToken:
+		return fixedWidth(groupToken{payload: t.payload})
+	case conditionalToken:
+		return fixedWidth(groupToken{payload: t.branches()})
+	case backreferenceToken:
+		return 0, false
+	case groupToken:
+		width := -1
+		for _, tokens := range t.payload {
+			sum := 0
+			for _, token := range tokens {
+				w, ok := fixedWidth(token)
+				if !ok {
+					return 0, false
+				}
+				sum += w
+			}
+			if width != -1 && sum != width {
+				return 0, false
+			}
+			width = sum
+		}
+		return max(width, 0), true
+	}
+
+	// Assertions consume nothing; every other token consumes a single rune.
+	switch t.Op() {
+	case OpBeginningOfString, OpEndOfString, OpStartOfInput, OpEndOfInput, OpWordBoundary, OpNonWordBoundary,
+		OpLookahead, OpLookbehind:
+		return 0, true
+	}
+	return 1, true
+}
+
+// backreferenceToken represents a backreference like '\\1', which matches the same text as the capturing group
+// with that number last matched. It never matches if the group has not matched.
+type backreferenceToken struct {
+	leaf
+	group int
+}
+
+// toNfa converts the backreference token to an NFA.
+func (t backreferenceToken) toNfa() *nfa {
+	end := &state{isFinal: true}
+	start := &state{backref: t.group, epsilon: []*state{end}}
+	return &nfa{start, end}
+}
+
+// Op returns OpBackreference.
+func (t backreferenceToken) Op() Op { return OpBackreference }
+
+// String renders the backreference token in pattern syntax.
+func (t backreferenceToken) String() string { return "\\" + strconv.Itoa(t.group) }
+
+// preferred returns the epsilon transitions of a quantifier that either repeats its payload or moves on to next,
+// in order of preference: repeating first for a greedy quantifier, moving on first for a lazy one.
+func preferred(lazy bool, repeat, next *state) []*state {
+	if lazy {
+		return []*state{next, repeat}
+	}
+	return []*state{repeat, next}
+}
+
+// nullable reports whether the NFA can reach its end without consuming input. Assertions are assumed to hold.
+func (n *nfa) nullable() bool {
+	seen := map[*state]bool{}
+	var reaches func(st *state) bool
+	reaches = func(st *state) bool {
+		if st == n.end {
+			return true
+		} else if seen[st] || st.backref > 0 || st.sub != nil && st.sub.kind == atomicSearch {
+			// A backreference or an atomic search moves the position on by the length of what it matched.
+			return false
+		}
+		seen[st] = true
+		return slices.ContainsFunc(st.epsilon, reaches)
+	}
+	return reaches(n.start)
+}
+
+// lazySuffix returns the '?' that marks a lazy quantifier in pattern syntax, or nothing for a greedy one.
+func lazySuffix(lazy bool) string {
+	if lazy {
+		return "?"
+	}
+	return ""
 }
 
 // wildcardToken represents a wildcard token.
-type wildcardToken struct{}
+// It matches any rune of the input except a newline, or any rune at all in dotAll mode.
+// Either way it never matches the BOS and EOS sentinels, so it cannot reach past the ends of the input.
+type wildcardToken struct {
+	leaf
+	dotAll bool
+}
 
 // toNfa converts the wildcard token to an NFA.
 func (t wildcardToken) toNfa() *nfa {
-	start := &state{}
+	start := &state{edges: make(map[rune][]*state)}
 	end := &state{isFinal: true}
+	if !t.dotAll {
+		deadEnd := &state{}
+		start.edges['\n'] = []*state{deadEnd}
+	}
 	start.anyChar = []*state{end}
 	return &nfa{start, end}
 }
 
+// Op returns OpWildcard.
+func (t wildcardToken) Op() Op { return OpWildcard }
+
+// String renders the wildcard token in pattern syntax.
+func (t wildcardToken) String() string { return "." }
+
 // groupToken represents a group of tokens.
+// If capturing is set, the index is the number of the capturing group, counting the opening parentheses
+// of capturing groups from 1. A non-capturing group, written "(?:...)", only groups and has index 0.
 type groupToken struct {
-	payload [][]token
+	payload   [][]Token
+	index     int
+	name      string
+	capturing bool
 }
 
 // toNfa converts the group token to an NFA.
+// An empty alternative, as in "(a|)", is wired as an epsilon transition from the group start to its end.
+// The start and end states record where the group matched.
 func (t groupToken) toNfa() *nfa {
-	start := &state{epsilon: []*state{}}
-	end := &state{isFinal: true}
+	start := &state{epsilon: []*state{}, openGroup: t.index}
+	end := &state{isFinal: true, closeGroup: t.index}
 	for _, tokens := range t.payload {
+		if len(tokens) == 0 {
+			start.epsilon = append(start.epsilon, end)
+			continue
+		}
+
 		var nfa *nfa
 		for _, token := range tokens {
 			nextNfa := token.toNfa()
@@ -555,13 +1867,112 @@ func (t groupToken) toNfa() *nfa {
 	return &nfa{start, end}
 }
 
+// Op returns OpGroup.
+func (t groupToken) Op() Op { return OpGroup }
+
+// Sub returns the alternatives of the group.
+func (t groupToken) Sub() [][]Token { return t.payload }
+
+// String renders the group token in pattern syntax.
+func (t groupToken) String() string {
+	open := "("
+	if !t.capturing {
+		open = "(?:"
+	} else if t.name != "" {
+		open = "(?P<" + t.name + ">"
+	}
+	return open + joinAlternatives(t.payload) + ")"
+}
+
+// joinAlternatives renders the alternatives of a group in pattern syntax, separated by '|'.
+func joinAlternatives(payload [][]Token) string {
+	alternatives := make([]string, len(payload))
+	for i, tokens := range payload {
+		alternatives[i] = joinTokens(tokens)
+	}
+	return strings.Join(alternatives, "|")
+}
+
+// assertion is a zero-width condition checked against the runes around byte offset pos of the prepared input.
+//
+// Every other transition in the NFA consumes exactly one rune, so an assertion cannot be an edge.
+// Instead it guards the epsilon transitions of the state it is attached to: the search inspects the input
+// without advancing, and if the assertion fails that path is abandoned. Because the whole prepared input is
+// available, an assertion may look behind the current position as well as ahead of it.
+type assertion func(input string, pos int) bool
+
 // state represents a state in the NFA.
+// If assert is set, the state consumes nothing and its epsilon transitions are only followed when the assertion holds.
+// If openGroup or closeGroup is set, passing through the state records the current position as the start or end
+// of that capturing group, and if matchStart is set, the start of the whole match. The id numbers the state
+// within its NFA.
+// If accepts is set, the anyChar transitions only consume the runes it accepts, which lets a class of runes
+// like a set or a Unicode category be a single transition instead of an edge per rune.
+// If backref is set, the state consumes the text that capturing group last matched, and follows its epsilon
+// transitions from after that text.
+// If sub is set, the state runs that search of a separate NFA first, and only follows its epsilon transitions
+// if the search succeeds, from the position the search reports.
+// If groupSet or groupUnset is set, the state only follows its epsilon transitions if that capturing group
+// has or has not matched.
 type state struct {
-	edges   map[rune][]*state
-	control map[rune][]*state
-	anyChar []*state
-	epsilon []*state
-	isFinal bool
+	id         int
+	edges      map[rune][]*state
+	anyChar    []*state
+	accepts    func(r rune) bool
+	epsilon    []*state
+	assert     assertion
+	openGroup  int
+	closeGroup int
+	matchStart bool
+	isFinal    bool
+	backref    int
+	groupSet   int
+	groupUnset int
+	sub        *subSearch
+}
+
+// subSearchKind tells what a subSearch looks for.
+type subSearchKind int
+
+const (
+	atomicSearch subSearchKind = iota // the preferred match starting at the position, continuing from its end
+	lookahead                         // a match starting at the position, continuing from the position itself
+	lookbehind                        // a match ending at the position, width runes long
+)
+
+// subSearch is a search of a separate NFA run by a state, which the enclosing search never backtracks into.
+// It implements possessive quantifiers and lookaround assertions. If negated is set, the search succeeds
+// when the NFA does not match, as in "(?!x)".
+type subSearch struct {
+	nfa       *nfa
+	numStates int
+	refs      []int
+	kind      subSearchKind
+	negated   bool
+	width     int
+}
+
+// newSubSearch numbers the states of n and returns a search of the given kind for it.
+func newSubSearch(n *nfa, kind subSearchKind, negated bool) *subSearch {
+	return &subSearch{nfa: n, numStates: n.numberStates(), refs: n.backrefSlots(), kind: kind, negated: negated}
+}
+
+// acceptsAny reports whether the anyChar transitions of the state may consume r.
+func (s *state) acceptsAny(r rune) bool {
+	return s.accepts == nil || s.accepts(r)
+}
+
+// captureSlot returns the index in the capture slice that the state records into, if any.
+// Group n records its start at 2n and its end at 2n+1; the start of the whole match is recorded at 0.
+func (s *state) captureSlot() (int, bool) {
+	if s.matchStart {
+		return 0, true
+	} else if s.openGroup > 0 {
+		return 2 * s.openGroup, true
+	} else if s.closeGroup > 0 {
+		return 2*s.closeGroup + 1, true
+	}
+	return 0, false
 }
 
 // nfa represents a Non-deterministic Finite Automaton.
@@ -571,7 +1982,13 @@ type nfa struct {
 }
 
 // buildNfa builds an NFA from the parsed regular expression.
-func buildNfa(tokens []token) *nfa {
+// An empty token list yields a single final state that matches the empty string.
+func buildNfa(tokens []Token) *nfa {
+	if len(tokens) == 0 {
+		st := &state{isFinal: true}
+		return &nfa{st, st}
+	}
+
 	var nfa *nfa
 	for _, token := range tokens {
 		nextNfa := token.toNfa()
@@ -586,76 +2003,442 @@ func buildNfa(tokens []token) *nfa {
 	return nfa
 }
 
-// matches takes a string s as input and recursively searches the NFA to determine if it reaches a final state.
-// It returns true if the NFA can match the part of input string, otherwise false.
-func (n *nfa) matches(s string) bool {
-	var checkMatch func(state *state, s string) bool
-	checkMatch = func(state *state, s string) bool {
-		if state.isFinal {
-			return true
+// unanchored returns an NFA that searches for the leftmost match of n at or after the starting position,
+// as if the pattern were prefixed with a lazy ".*?". At each position the NFA is first tried from there,
+// and only if that fails is one more rune skipped, so a single search replaces a scan from every offset.
+// The state entering n records the start of the match in capture slot 0.
+func (n *nfa) unanchored() *nfa {
+	begin := &state{epsilon: []*state{n.start}, matchStart: true}
+	loop := &state{}
+	skip := &state{anyChar: []*state{loop}}
+	loop.epsilon = []*state{begin, skip}
+	return &nfa{loop, n.end}
+}
+
+// anchoredStart returns an NFA that only matches n from the starting position, with no skipping ahead.
+// Like the NFA of unanchored, it records the start of the match in capture slot 0.
+func (n *nfa) anchoredStart() *nfa {
+	begin := &state{epsilon: []*state{n.start}, matchStart: true}
+	return &nfa{begin, n.end}
+}
+
+// numberStates assigns consecutive ids to every state reachable from the start state and returns their count.
+func (n *nfa) numberStates() int {
+	numStates := 0
+	n.walk(func(st *state) {
+		st.id = numStates
+		numStates++
+	})
+	return numStates
+}
+
+// backrefSlots returns the capture slots that the backreferences and conditional groups in the NFA read,
+// in increasing order, including those in the NFAs of sub-searches.
+func (n *nfa) backrefSlots() []int {
+	var slots []int
+	n.walk(func(st *state) {
+		if st.backref > 0 {
+			slots = append(slots, 2*st.backref, 2*st.backref+1)
+		}
+		if group := max(st.groupSet, st.groupUnset); group > 0 {
+			slots = append(slots, 2*group+1)
 		}
+		if st.sub != nil {
+			slots = append(slots, st.sub.refs...)
+		}
+	})
+	slices.Sort(slots)
+	return slices.Compact(slots)
+}
 
-		r, w := utf8.DecodeRuneInString(s)
-		if unicode.IsPrint(r) {
-			if st := state.edges[r]; st != nil {
-				if checkMatch(st[0], s[w:]) {
-					return true
-				}
-			} else if state.anyChar != nil {
-				if checkMatch(state.anyChar[0], s[w:]) {
-					return true
-				}
+// walk calls visit once for every state reachable from the start state, but not for the states of sub-searches.
+func (n *nfa) walk(visit func(st *state)) {
+	seen := map[*state]bool{}
+	var walk func(st *state)
+	walk = func(st *state) {
+		if seen[st] {
+			return
+		}
+		seen[st] = true
+		visit(st)
+
+		for _, targets := range st.edges {
+			for _, target := range targets {
+				walk(target)
 			}
+		}
+		for _, target := range st.anyChar {
+			walk(target)
+		}
+		for _, target := range st.epsilon {
+			walk(target)
+		}
+	}
+	walk(n.start)
+}
+
+// visitSet records the (state, position) pairs that a search has already explored.
+// A pair met again is skipped: either it failed before, or it lies on the path still being explored, in which
+// case the search goes on with the next alternative, much as RE2 drops a thread for an instruction it already
+// holds at that position. The first path to reach a pair wins it, so the shape of the NFA decides which match
+// is preferred; starToken.toNfa relies on that. Skipping such pairs bounds a search by the number of states
+// times the input length, so patterns like "(a|a)*b" no longer backtrack exponentially. Whether a pair can
+// reach a final state does not depend on how it was reached, so one set can be shared by the searches from
+// every start position in the same input.
+//
+// Backreferences break that rule: whether one matches depends on the text its group captured on the way.
+// For an NFA with backreferences, the offsets in the capture slots they read become part of each pair,
+// kept in the keyed map instead of the bits. Such searches may take exponential time, as in other
+// backtracking engines, but they still cannot loop forever on an epsilon cycle.
+//
+// The bits cover every state at every position of the input, but a search usually explores a small part of them,
+// so the words it sets are listed in dirty, and reset only clears those. Once the list would grow past an eighth
+// of the words, it is dropped and reset clears them all, which costs no more than the search that set them.
+type visitSet struct {
+	bits     []uint64
+	dirty    []int
+	dirtyAll bool
+	width    int
+	keyed    map[visitKey]bool
+}
+
+// visitKey identifies a state at a position together with the capture offsets read by backreferences.
+type visitKey struct {
+	id, pos int
+	caps    string
+}
+
+// reset empties the set and sizes it for an NFA with numStates states and a prepared input of length inputLen,
+// reusing the memory of earlier searches when it is large enough.
+func (v *visitSet) reset(numStates, inputLen int) {
+	v.width = inputLen + 1
+	clear(v.keyed)
+	n := (numStates*v.width + 63) / 64
+	if cap(v.bits) < n {
+		v.bits = make([]uint64, n)
+		v.dirty, v.dirtyAll = v.dirty[:0], false
+		return
+	}
+
+	if v.dirtyAll {
+		clear(v.bits)
+	} else {
+		for _, w := range v.dirty {
+			v.bits[w] = 0
+		}
+	}
+	v.bits = v.bits[:n]
+	v.dirty, v.dirtyAll = v.dirty[:0], false
+}
+
+// visit marks the pair of state and pos as explored and reports whether it had not been explored before.
+func (v *visitSet) visit(st *state, pos int) bool {
+	i := st.id*v.width + pos
+	w, bit := i/64, uint64(1)<<(i%64)
+	if v.bits[w]&bit != 0 {
+		return false
+	}
+
+	if v.bits[w] == 0 && !v.dirtyAll {
+		if len(v.dirty) < len(v.bits)/8 {
+			v.dirty = append(v.dirty, w)
 		} else {
-			if st := state.control[r]; st != nil {
-				if checkMatch(st[0], s[w:]) {
-					return true
-				}
+			v.dirtyAll = true
+		}
+	}
+	v.bits[w] |= bit
+	return true
+}
+
+// visitCaps is like visit, but also takes the offsets in the capture slots refs into account.
+func (v *visitSet) visitCaps(st *state, pos int, caps, refs []int) bool {
+	var b []byte
+	for _, slot := range refs {
+		b = binary.AppendVarint(b, int64(caps[slot]))
+	}
+
+	key := visitKey{st.id, pos, string(b)}
+	if v.keyed[key] {
+		return false
+	} else if v.keyed == nil {
+		v.keyed = map[visitKey]bool{}
+	}
+	v.keyed[key] = true
+	return true
+}
+
+// job is an entry on the backtracking stack of matches. It either asks to explore a state at a position,
+// or, if restore is set, to put back the capture slot to the old offset held in pos.
+type job struct {
+	state   *state
+	pos     int
+	slot    int
+	restore bool
+}
+
+// machine holds the scratch space of a search: the explored pairs, the backtracking stack and the best captures
+// found so far in longest mode. Keeping it between searches saves allocating them again for every input.
+// The sub machine runs the searches of states with a subSearch, one level of nesting deeper.
+// The refs are the capture slots read by the backreferences of the NFAs the machine searches, if any.
+// If ctx is set, searches check it every checkInterval steps and fail once it is done, leaving its error in err.
+// Steps counts the steps taken by the searches of the machine, including those of its sub machine, and if maxSteps
+// is positive, a search that takes more fails with ErrStepLimit in err.
+type machine struct {
+	visits   visitSet
+	stack    []job
+	best     []int
+	sub      *machine
+	refs     []int
+	ctx      context.Context
+	err      error
+	steps    int
+	maxSteps int
+}
+
+// checkInterval is the number of steps a search takes between checks of the context of its machine.
+const checkInterval = 1024
+
+// matchSub runs the subSearch of a state at pos, recording the positions of the capturing groups inside it into caps.
+// It returns the position the enclosing search continues from and true, or false if the search fails.
+// The search is independent of the enclosing one, so it gets a fresh set of explored pairs.
+func (m *machine) matchSub(sub *subSearch, input string, pos int, caps []int) (int, bool) {
+	if m.sub == nil {
+		m.sub = &machine{}
+	}
+	m.sub.visits.reset(sub.numStates, len(input))
+	m.sub.refs = sub.refs
+	m.sub.ctx = m.ctx
+	m.sub.steps, m.sub.maxSteps = m.steps, m.maxSteps
+
+	start := pos
+	if sub.kind == lookbehind {
+		// Every match of a lookbehind is width runes long, so it ends at pos if it starts width runes back.
+		for range sub.width {
+			if start == bosWidth {
+				return pos, sub.negated
 			}
+			_, w := utf8.DecodeLastRuneInString(input[:start])
+			start -= w
 		}
+	}
+
+	end, ok := m.sub.matches(sub.nfa, input, start, caps, false)
+	m.steps = m.sub.steps
+	if m.sub.err != nil {
+		m.err = m.sub.err
+		return 0, false
+	}
+	if sub.kind != atomicSearch {
+		end = pos
+	}
+	return end, ok != sub.negated
+}
 
-		for _, st := range state.epsilon {
-			if checkMatch(st, s) {
-				return true
+// matches searches the NFA, starting at byte offset pos of the prepared input, to determine if it reaches a final state.
+// The whole input is passed so that zero-width assertions can look at the runes on either side of the current position.
+// The positions of capturing groups along the successful path are recorded into the slots that caps has room for;
+// a slot is restored when the search backtracks out of the path that set it.
+// The visits set of the machine skips the pairs of state and position that were already explored; the caller resets it
+// for each new input, and may share it between calls on the same input.
+// The alternatives are kept on an explicit stack, highest priority on top, so the depth of the search is not limited
+// by the goroutine stack however long the input is.
+// If longest is set, the search goes on after reaching a final state and settles on the path that ends furthest,
+// preferring the first one found among paths of equal length.
+// It returns the byte offset where the match ends and true if the NFA can match the part of input string starting at pos,
+// otherwise false.
+// If the context of the machine is done, the search fails and leaves the error of the context in err.
+// Likewise, if it takes more than maxSteps steps in all, the search fails and leaves ErrStepLimit in err.
+// Backreferences need the groups they read to be recorded, so if caps has no room for them, the search records
+// into a longer slice and copies back the slots caps has room for.
+func (m *machine) matches(n *nfa, input string, pos int, caps []int, longest bool) (int, bool) {
+	if len(m.refs) > 0 && len(caps) <= m.refs[len(m.refs)-1] {
+		wider := make([]int, m.refs[len(m.refs)-1]+1)
+		for i := range wider {
+			wider[i] = -1
+		}
+		copy(wider, caps)
+		defer copy(caps, wider)
+		caps = wider
+	}
+
+	best := m.best[:0]
+	bestEnd, matched := 0, false
+	stack := append(m.stack[:0], job{state: n.start, pos: pos})
+	defer func() { m.stack, m.best = stack[:0], best[:0] }()
+	for steps := 1; len(stack) > 0; steps++ {
+		if m.ctx != nil && steps%checkInterval == 0 {
+			if m.err = m.ctx.Err(); m.err != nil {
+				return 0, false
 			}
 		}
+		m.steps++
+		if m.maxSteps > 0 && m.steps > m.maxSteps {
+			m.err = ErrStepLimit
+			return 0, false
+		}
 
-		return false
+		j := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		st, pos := j.state, j.pos
+		if j.restore {
+			caps[j.slot] = pos
+			continue
+		}
+
+		if len(m.refs) > 0 {
+			if !m.visits.visitCaps(st, pos, caps, m.refs) {
+				continue
+			}
+		} else if !m.visits.visit(st, pos) {
+			continue
+		}
+
+		if slot, ok := st.captureSlot(); ok && slot < len(caps) {
+			stack = append(stack, job{pos: caps[slot], slot: slot, restore: true})
+			caps[slot] = pos
+		}
+
+		if st.isFinal {
+			if !longest {
+				return pos, true
+			}
+			if !matched || pos > bestEnd {
+				best = append(best[:0], caps...)
+				bestEnd, matched = pos, true
+			}
+			continue
+		}
+
+		if st.assert != nil && !st.assert(input, pos) {
+			continue
+		}
+		// A group has matched once its end is recorded.
+		if st.groupSet > 0 && caps[2*st.groupSet+1] < 0 || st.groupUnset > 0 && caps[2*st.groupUnset+1] >= 0 {
+			continue
+		}
+
+		if st.backref > 0 {
+			start, end := caps[2*st.backref], caps[2*st.backref+1]
+			if start < 0 || end < 0 || !strings.HasPrefix(input[pos:len(input)-eosWidth], input[start:end]) {
+				continue
+			}
+			for i := len(st.epsilon) - 1; i >= 0; i-- {
+				stack = append(stack, job{state: st.epsilon[i], pos: pos + end - start})
+			}
+			continue
+		}
+
+		if st.sub != nil {
+			saved := slices.Clone(caps)
+			end, ok := m.matchSub(st.sub, input, pos, caps)
+			if m.err != nil {
+				return 0, false
+			} else if !ok {
+				// A negated search may have matched and recorded groups before failing.
+				copy(caps, saved)
+				continue
+			}
+			// Put back the groups recorded inside the sub-search if the search backtracks past it.
+			for slot, old := range saved {
+				if caps[slot] != old {
+					stack = append(stack, job{pos: old, slot: slot, restore: true})
+				}
+			}
+			for i := len(st.epsilon) - 1; i >= 0; i-- {
+				stack = append(stack, job{state: st.epsilon[i], pos: end})
+			}
+			continue
+		}
+
+		// Push the alternatives in reverse order so that the first one is explored first.
+		for i := len(st.epsilon) - 1; i >= 0; i-- {
+			stack = append(stack, job{state: st.epsilon[i], pos: pos})
+		}
+
+		// The EOS character is not part of the input, so no edge ever consumes it.
+		if pos < len(input)-eosWidth {
+			r, w := utf8.DecodeRuneInString(input[pos:])
+			if next := st.edges[r]; next != nil {
+				stack = append(stack, job{state: next[0], pos: pos + w})
+			} else if st.anyChar != nil && st.acceptsAny(r) {
+				stack = append(stack, job{state: st.anyChar[0], pos: pos + w})
+			}
+		}
 	}
 
-	return checkMatch(n.start, s)
+	if matched {
+		copy(caps, best)
+	}
+	return bestEnd, matched
 }
 
-// stringSource prepares the input string for matching by replacing newline characters with the beginning-of-string character.
-// It also prepends the BOS character to the start of the string.
+// eosWidth is the byte width of the EOS character at the end of a prepared string.
+var eosWidth = utf8.RuneLen(EOS)
+
+// stringSource prepares the input string for matching by surrounding it with the BOS and EOS characters.
+// The anchors detect the characters with assertions and never consume them, so offset i of the input
+// is offset i+1 of the prepared string. Newlines are left in place; line anchors in multiline mode
+// detect them with assertions as well.
+// The input may contain the BOS and EOS bytes itself: the matcher only ever looks for the sentinels
+// at the two ends of the prepared string, so any occurrence in between is matched like any other character.
 func stringSource(input string) string {
-	preparedString := strings.ReplaceAll(input, "\n", string(EOS)+string(BOS))
-	preparedString = string(BOS) + preparedString + string(EOS)
-	return preparedString
+	return string(BOS) + input + string(EOS)
+}
+
+// bytesSource is like stringSource for an input held in a byte slice. Preparing the input copies it once,
+// as stringSource does, so the caller needs no conversion to a string beforehand.
+func bytesSource(input []byte) string {
+	var sb strings.Builder
+	sb.Grow(bosWidth + len(input) + eosWidth)
+	sb.WriteRune(BOS)
+	sb.Write(input)
+	sb.WriteRune(EOS)
+	return sb.String()
 }
 
 // Match checks if the given line contains any match of the specified regular expression pattern.
 // It returns true if a match is found, otherwise false. If the pattern is invalid, it returns an error.
+// Recently used patterns are kept compiled; see SetMatchCacheSize.
 func Match(line, pattern string) (bool, error) {
-	if pattern == "" {
-		return true, nil
+	re, err := matchCache.compile(pattern)
+	if err != nil {
+		return false, err
 	}
 
-	p := parser{regexp: pattern}
-	err := p.parse()
+	return re.MatchString(line), nil
+}
+
+// MatchIndex is like Match but also returns the byte offsets in line where the leftmost match starts and ends,
+// so that line[start:end] is the matched text. If there is no match, the offsets are both 0.
+func MatchIndex(line, pattern string) (int, int, bool, error) {
+	re, err := matchCache.compile(pattern)
 	if err != nil {
-		return false, err
+		return 0, 0, false, err
 	}
 
-	line = stringSource(line)
-	nfa := buildNfa(p.tokens)
-	for len(line) > 0 {
-		if nfa.matches(line) {
-			return true, nil
-		}
-		_, runeSize := utf8.DecodeRuneInString(line)
-		line = line[runeSize:]
+	loc := re.find(&machine{}, stringSource(line), 0, 2)
+	if loc == nil {
+		return 0, 0, false, nil
+	}
+	return loc[0], loc[1], true, nil
+}
+
+// FullMatch checks if the regular expression pattern matches the whole of s, from its start to its end,
+// rather than just some part of it as with Match. If the pattern is invalid, it returns an error.
+func FullMatch(s, pattern string) (bool, error) {
+	p := parser{regexp: pattern}
+	if err := p.parse(); err != nil {
+		return false, err
 	}
 
-	return false, nil
+	// The end of input assertion makes the search backtrack into any alternative that reaches further.
+	nfa := buildNfa(append(p.tokens, endOfInputToken{}))
+	numStates := nfa.numberStates()
+
+	input := stringSource(s)
+	m := &machine{refs: nfa.backrefSlots()}
+	m.visits.reset(numStates, len(input))
+	_, ok := m.matches(nfa, input, bosWidth, nil, false)
+	return ok, nil
 }
@@ -2,7 +2,12 @@ package re
 
 import (
 	"errors"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestMatch(t *testing.T) {
@@ -17,6 +22,33 @@ func TestMatch(t *testing.T) {
 		{"b", "a", false, nil, false},
 		{"", "a", false, nil, false},
 		{"a", "", true, nil, false},
+		{"", "()", true, nil, false},
+		{"ab", "abc", false, nil, false},
+		{"ab", "ab.", false, nil, false},
+		{"ab", "ab[^x]", false, nil, false},
+		{"a", "a\\x{FFFD}", false, nil, false},
+		{"a", "a[^a]", false, nil, false},
+		{"a\uFFFD", "a\\x{FFFD}", true, nil, false},
+		{"a\xff", "a\\x{FFFD}", true, nil, false},
+		{"aba", "(ab)\\1", false, nil, false},
+		{"\uffff", "^[\\x00-\\uFFFF]$", true, nil, false},
+		{"\U00010000", "^[\\x00-\\uFFFF]$", false, nil, false},
+		{"\U00010000", "^[^\\x00-\\uFFFF]$", true, nil, false},
+		{"aaaa", "^a**$", true, nil, false},
+		{"b", "^(?:a*)*b", true, nil, false},
+		{"aab", "^(?:a?)+$", false, nil, false},
+		{"2024", "(?P<year>\\d{4})", true, nil, false},
+		{"abab", "(?<pair>ab)\\1", true, nil, false},
+		{"ab", "(?<=a)b", true, nil, false},
+		{"a", "(?P<>a)", false, errors.New("invalid named capture: (?P<>"), true},
+		{"a", "(?P<1a>a)", false, errors.New("invalid named capture: (?P<1a>"), true},
+		{"a", "(?<a-b>a)", false, errors.New("invalid named capture: (?<a-b>"), true},
+		{"a", "(?P<name", false, errors.New("invalid named capture: (?P<name"), true},
+		{"aa", "(?P<x>a)(?P<x>a)", false, errors.New("duplicate capture group name: x"), true},
+		{"a", "()a", true, nil, false},
+		{"ab", "a()b", true, nil, false},
+		{"b", "a()b", false, nil, false},
+		{"a", "(()|x)a", true, nil, false},
 		{"3", "d", false, nil, false},
 		{"3", "\\d", true, nil, false},
 		{"d", "\\d", false, nil, false},
@@ -30,11 +62,60 @@ func TestMatch(t *testing.T) {
 		{"foo101", "\\w", true, nil, false},
 		{"$!?", "\\w", false, nil, false},
 		{"a", "\\@", false, errors.New("unsupported meta character: \\@"), true},
+		{"a.b", "a\\.b", true, nil, false},
+		{"axb", "a\\.b", false, nil, false},
+		{"(a)", "\\(a\\)", true, nil, false},
+		{"a+b", "a\\+b", true, nil, false},
+		{"aab", "a\\+b", false, nil, false},
+		{"a{2}", "a\\{2\\}", true, nil, false},
+		{"$5", "^\\$\\d", true, nil, false},
+		{"A", "\\x41", true, nil, false},
+		{"B", "\\x41", false, nil, false},
+		{"JAVA", "J\\x41V\\x41", true, nil, false},
+		{"😀", "\\x{1F600}", true, nil, false},
+		{"A", "\\x{41}", true, nil, false},
+		{"A", "\\xZZ", false, errors.New("invalid hex escape: \\xZZ"), true},
+		{"A", "\\x4", false, errors.New("invalid hex escape: \\x4"), true},
+		{"A", "\\x{41", false, errors.New("unclosed hex escape: \\x{"), true},
+		{"A", "\\x{110000}", false, errors.New("invalid hex escape: \\x{110000}"), true},
+		{"é", "\\u00e9", true, nil, false},
+		{"e", "\\u00e9", false, nil, false},
+		{"café", "caf\\u00E9", true, nil, false},
+		{"😀", "\\U0001F600", true, nil, false},
+		{"é", "\\u00e", false, errors.New("invalid unicode escape: \\u00e"), true},
+		{"é", "\\u00g9", false, errors.New("invalid unicode escape: \\u00g9"), true},
+		{"é", "\\U00110000", false, errors.New("invalid unicode escape: \\U00110000"), true},
+		// Multibyte runes are matched whole, wherever they appear.
+		{"日本語", "本", true, nil, false},
+		{"日本語", "語$", true, nil, false},
+		{"日本語", "^日本", true, nil, false},
+		{"日本語", "^本", false, nil, false},
+		{"café", "caf.", true, nil, false},
+		{"café", "^caf.$", true, nil, false},
+		{"a本b", "a.b", true, nil, false},
+		{"日本語", "^...$", true, nil, false},
+		{"日本語", "^.{3}$", true, nil, false},
+		{"日本語", "^..$", false, nil, false},
+		{"本本", "^本+$", true, nil, false},
+		{"本", "[^本]", false, nil, false},
+		{"本x", "[^本]", true, nil, false},
+		{"日本", "^[^本]本$", true, nil, false},
+		{"語", "^[日-語]$", true, nil, false},
+		{"\xff", "^.$", true, nil, false},
+		{"a", "a\\", false, errors.New("trailing backslash in pattern"), true},
+		{"\\", "\\", false, errors.New("trailing backslash in pattern"), true},
+		{"a\\", "a\\\\", true, nil, false},
 		{"apple", "[abc]", true, nil, false},
 		{"dog", "[abc]", false, nil, false},
 		{"a", "[a-c]", true, nil, false},
 		{"b", "[a-c]", true, nil, false},
 		{"d", "[a-c]", false, nil, false},
+		{"]", "[]a]", true, nil, false},
+		{"b", "[]a]", false, nil, false},
+		{"]", "[^]a]", false, nil, false},
+		{"b", "[^]a]", true, nil, false},
+		{"]", "[]-a]", true, nil, false},
+		{"", "[]", false, errors.New("unexpected EOF while parsing positive set"), true},
 		{"a", "[c-a]", false, errors.New("invalid range: c-a"), true},
 		{"a", "[a-]", true, nil, false},
 		{"b", "[a-]", false, nil, false},
@@ -46,8 +127,81 @@ func TestMatch(t *testing.T) {
 		{"a", "[^a-]", false, nil, false},
 		{"b", "[^a-]", true, nil, false},
 		{"-", "[^a-]", false, nil, false},
+		{"-", "[^-a]", false, nil, false},
+		{"a", "[^-a]", false, nil, false},
+		{"b", "[^-a]", true, nil, false},
+		{"-", "[-a]", true, nil, false},
+		{"b", "[-a]", false, nil, false},
+		{"a", "[-a]", true, nil, false},
+		{"-", "[-]", true, nil, false},
+		{"-", "[-a-c]", true, nil, false},
+		{"b", "[-a-c]", true, nil, false},
+		{"d", "[-a-c]", false, nil, false},
+		{".", "[--/]", true, nil, false},
+		{"0", "[--/]", false, nil, false},
+		// Metacharacters are literals inside a set.
+		{"|", "[a|b]", true, nil, false},
+		{"ab", "^[a|b]$", false, nil, false},
+		{"(", "[()]", true, nil, false},
+		{")", "[()]", true, nil, false},
+		{"*", "[*+?]", true, nil, false},
+		{"+", "[*+?]", true, nil, false},
+		{"?", "[*+?]", true, nil, false},
+		{"", "[*+?]", false, nil, false},
+		{".", "[.]", true, nil, false},
+		{"x", "[.]", false, nil, false},
+		{"$", "[$^]", true, nil, false},
+		{"^", "[$^]", true, nil, false},
+		{"{", "[{}]", true, nil, false},
+		{"}", "[{}]", true, nil, false},
+		{"|", "[^a|b]", false, nil, false},
+		{"c", "[^|()*+?.]", true, nil, false},
+		{"*", "[^|()*+?.]", false, nil, false},
+		{"x\ny", "x[^a]y", true, nil, false},
+		{"a\tb", "a[^x]b", true, nil, false},
+		{"a\tb", "a\\x09b", true, nil, false},
+		{"a\tb", "a.b", true, nil, false},
+		{"x\ny", "x.y", false, nil, false},
+		{"", "[^a]", false, nil, false},
+		{"b", "[^a]$", true, nil, false},
+		{"5", "[\\x30-\\x39]", true, nil, false},
+		{"a", "[\\x30-\\x39]", false, nil, false},
+		{"5", "[0-\\x39]", true, nil, false},
+		{"5", "[\\u0030-9]", true, nil, false},
+		{"a", "[^\\x30-\\x39]", true, nil, false},
+		{"5", "[^\\x30-\\x39]", false, nil, false},
+		{"x\ny", "x[^\\x0a]y", false, nil, false},
+		{"]", "[\\]]", true, nil, false},
+		{"-", "[a\\-z]", true, nil, false},
+		{"b", "[a\\-z]", false, nil, false},
+		{"\\", "[\\\\]", true, nil, false},
+		{"a", "[\\x39-\\x30]", false, errors.New("invalid range: 9-0"), true},
+		{"a", "[\\d]", false, errors.New("unsupported escape in positive set: \\d"), true},
+		{"a", "[\\xZZ]", false, errors.New("invalid hex escape: \\xZZ"), true},
 		{"dog", "[^abc]", true, nil, false},
 		{"cab", "[^abc]", false, nil, false},
+		{"5", "[[:digit:]]", true, nil, false},
+		{"a", "[[:digit:]]", false, nil, false},
+		{"a", "[^[:digit:]]", true, nil, false},
+		{"5", "[^[:digit:]]", false, nil, false},
+		{"Q", "[[:alpha:]]", true, nil, false},
+		{"q", "[[:upper:]]", false, nil, false},
+		{"q", "[[:lower:]]", true, nil, false},
+		{"_", "[[:alnum:]]", false, nil, false},
+		{"a b", "a[[:space:]]b", true, nil, false},
+		{"!", "[[:punct:]]", true, nil, false},
+		{"x", "[[:punct:]x]", true, nil, false},
+		{"-", "[a[:digit:]-]", true, nil, false},
+		{"a", "[[:^digit:]]", true, nil, false},
+		{"5", "[[:^digit:]]", false, nil, false},
+		{"é", "[[:^alpha:]]", true, nil, false},
+		{"\n", "[[:^space:]]", false, nil, false},
+		{"\x0e", "[[:^space:]]", true, nil, false},
+		{"5", "[^[:^digit:]]", true, nil, false},
+		{"a", "[^[:^digit:]]", false, nil, false},
+		{"a", "[[:^digit:][:digit:]]", true, nil, false},
+		{"a", "[[:^word:]]", false, errors.New("unknown POSIX class: [:^word:]"), true},
+		{"a", "[[:word:]]", false, errors.New("unknown POSIX class: [:word:]"), true},
 		{"1 apple", "\\d apple", true, nil, false},
 		{"1 orange", "\\d apple", false, nil, false},
 		{"100 apple", "\\d\\d\\d apple", true, nil, false},
@@ -58,13 +212,68 @@ func TestMatch(t *testing.T) {
 		{"sally has 3 apples", "\\d apple", true, nil, false},
 		{"sally has 1 orange", "\\d apple", false, nil, false},
 		{"sally has 12 apples", "\\d \\\\d\\\\d apples", false, nil, false},
+		{"cat dog", "\\bcat\\b", true, nil, false},
+		{"category", "\\bcat\\b", false, nil, false},
+		{"bobcat", "\\bcat", false, nil, false},
+		{"bobcat", "cat\\b", true, nil, false},
+		{"a cat.", "\\bcat\\b", true, nil, false},
+		{"", "\\b", false, nil, false},
+		{"category", "cat\\B", true, nil, false},
+		{"cat dog", "cat\\B", false, nil, false},
+		{"cat", "\\Bat", true, nil, false},
+		{"at", "\\Bat", false, nil, false},
+		{"", "\\B", true, nil, false},
 		{"log file", "^log", true, nil, false},
 		{"error log", "^log", false, nil, false},
 		{"dog", "dog$", true, nil, false},
 		{"dogs", "dog$", false, nil, false},
+		{"a dog", "dog$", true, nil, false},
+		{"dogs", "dogs?$", true, nil, false},
+		{"dog", "dogs?$", true, nil, false},
+		{"dogsy", "dogs?$", false, nil, false},
+		{"b", "a*$", true, nil, false},
+		{"baa", "ba*$", true, nil, false},
+		{"bac", "ba*$", false, nil, false},
+		{"aab", "a+$", false, nil, false},
+		{"baa", "a+$", true, nil, false},
+		{"ac", "b*c", true, nil, false},
+		{"foo\nbar", "^bar", false, nil, false},
+		{"foo\nbar", "(?m)^bar", true, nil, false},
+		{"foo\nbar", "\\Abar", false, nil, false},
+		{"foo\nbar", "(?m)\\Abar", false, nil, false},
+		{"foo\nbar", "\\Afoo", true, nil, false},
+		{"foo\nbar", "foo$", false, nil, false},
+		{"foo\nbar", "(?m)foo$", true, nil, false},
+		{"foo\nbar", "(?m)foo\\z", false, nil, false},
+		{"foo\nbar", "bar\\z", true, nil, false},
+		{"foo\nbar", "(?m)^foo", true, nil, false},
+		{"foo", "(?x)foo", false, errors.New("unsupported flag: x"), true},
+		// '^' and '$' are anchors only at the ends of the pattern, a group or an alternative, except in multiline mode.
+		{"a$b", "a\\$b", true, nil, false},
+		{"a^b", "a\\^b", true, nil, false},
+		{"a$b", "a$b", true, nil, false},
+		{"ab", "a$b", false, nil, false},
+		{"a^b", "a^b", true, nil, false},
+		{"x$", "$$", true, nil, false},
+		{"$", "^$$", true, nil, false},
+		{"ab", "(a$|b)", true, nil, false},
+		{"ba", "(a$|b$)", true, nil, false},
+		{"ab", "x|^b", false, nil, false},
+		{"ba", "(c|^b)a", true, nil, false},
+		{"ab", "(?:^b)", false, nil, false},
+		{"a\nb", "(?m)a$\n^b", true, nil, false},
+		{"a$\n^b", "(?m)a$\n^b", false, nil, false},
 		{"eels", "e+", true, nil, false},
 		{"els", "e+", true, nil, false},
 		{"ls", "e+", false, nil, false},
+		{"a*", "*a", false, nil, false},
+		{"*a", "*a", true, nil, false},
+		{"+", "+", true, nil, false},
+		{"a?", "?", true, nil, false},
+		{"***", "**", true, nil, false},
+		{"x", "**", true, nil, false},
+		{"*b", "(a|*b)", true, nil, false},
+		{"?", "(?", false, errors.New("unexpected EOF while parsing flags"), true},
 		{"dogs", "dogs?", true, nil, false},
 		{"dog", "dogs?", true, nil, false},
 		{"cat", "dogs?", false, nil, false},
@@ -79,10 +288,220 @@ func TestMatch(t *testing.T) {
 		{"a cat", "a (cat|dog)", true, nil, false},
 		{"a dog", "a (cat|dog)", true, nil, false},
 		{"a cow", "a (cat|dog)", false, nil, false},
+		{"", "(a|)", true, nil, false},
+		{"a", "(a|)", true, nil, false},
+		{"", "(|a)", true, nil, false},
+		{"", "|abc", true, nil, false},
+		{"abc", "|abc", true, nil, false},
+		{"x", "^(?:|abc)$", false, nil, false},
+		{"abc", "^(?:|abc)$", true, nil, false},
+		{"abc", "^(?:||abc|)$", true, nil, false},
+		{"a", "(a|)b", false, nil, false},
+		{"b", "(a|)b", true, nil, false},
+		{"ab", "(a|)b", true, nil, false},
+		// Anchors bind to their own alternative, as in "(?:^a)|(?:b$)".
+		{"ab", "^a|b$", true, nil, false},
+		{"a", "^a|b$", true, nil, false},
+		{"b", "^a|b$", true, nil, false},
+		{"ba", "^a|b$", false, nil, false},
+		{"xb", "^a|b$", true, nil, false},
+		{"bx", "^a|b$", false, nil, false},
+		{"bx", "a$|^b", true, nil, false},
+		{"xb", "a$|^b", false, nil, false},
+		{"xb", "(^a|b$)", true, nil, false},
+		{"xbx", "x(^a|b$)", false, nil, false},
 		{"a", "ab*", true, nil, false},
 		{"ab", "ab*", true, nil, false},
 		{"abb", "ab*", true, nil, false},
 		{"ac", "ab*", true, nil, false},
+		{"abab", "^(?:ab)+$", true, nil, false},
+		{"aba", "^(?:ab)+$", false, nil, false},
+		{"dog", "(?:cat|dog)", true, nil, false},
+		{"cow", "(?:cat|dog)", false, nil, false},
+		{"ab", "ab|cd|ef", true, nil, false},
+		{"cd", "ab|cd|ef", true, nil, false},
+		{"ef", "ab|cd|ef", true, nil, false},
+		{"ax", "ab|cd|ef", false, nil, false},
+		{"xcdx", "ab|cd|ef", true, nil, false},
+		{"ad", "ab|cd|ef", false, nil, false},
+		{"b", "(a)|b", true, nil, false},
+		{"a", "(a)|b", true, nil, false},
+		{"ab", "^a$|^b", false, nil, false},
+		{"ba", "^a$|^b", true, nil, false},
+		{"", "a|", true, nil, false},
+		{"xa", "^(a|b)|x", true, nil, false},
+		{"a\x02b", "^b", false, nil, false},
+		{"a\x03b", "a$", false, nil, false},
+		{"a\x02b", "^a\x02b$", true, nil, false},
+		{"\x02\x03", "^\x02\x03$", true, nil, false},
+		{"a\x03", "a\\x{3}$", true, nil, false},
+		{"\x02", "^[^a]$", true, nil, false},
+		{"\x02", "^.$", true, nil, false},
+		{"x\x03\ny", "(?m)^y", true, nil, false},
+		{"x\x03y", "(?m)x$", false, nil, false},
+		{"foo\nbar\n", "^bar", false, nil, false},
+		{"foo\nbar\n", "(?m)^bar", true, nil, false},
+		{"foo\nbar\n", "foo$", false, nil, false},
+		{"foo\nbar\n", "(?m)foo$", true, nil, false},
+		{"foo\nbar\n", "bar$", false, nil, false},
+		{"foo\nbar\n", "(?m)bar$", true, nil, false},
+		{"foo\nbar\n", "(?m)^$", true, nil, false},
+		{"foo\nbar\n", "foo.bar", false, nil, false},
+		{"foo\nbar\n", "(?s)foo.bar", true, nil, false},
+		{"foo\nbar\n", "(?ms)^foo.bar$", true, nil, false},
+		{"foo\nbar\n", "foo\nbar", true, nil, false},
+		{"foo\nbar\n", "foo[^x]bar", true, nil, false},
+		{"foo\nbar\n", "(x|(?s)o.b)", true, nil, false},
+		{"a\nb", "a.b", false, nil, false},
+		{"a\nb", "(?s)a.b", true, nil, false},
+		{"a\nxb", "a.*b", false, nil, false},
+		{"a\nxb", "(?s)a.*b", true, nil, false},
+		{"a\nb", "(?m)a.$", false, nil, false},
+		{"", ".", false, nil, false},
+		{"", "(?s).", false, nil, false},
+		{"a", "(?s)a.", false, nil, false},
+		{"a", "(?s).a", false, nil, false},
+		{"\n", "(?s)^.$", true, nil, false},
+		{"\x05", "^[\\x00-\\x10]$", true, nil, false},
+		{"-", "^[\\x00-\\x10]$", false, nil, false},
+		{"😀", "^[\\x00-\\U0010FFFF]$", true, nil, false},
+		{"😀", "[^\\x00-\\U0010FFFF]", false, nil, false},
+		{"é", "^[\\u00e0-\\u00ff]$", true, nil, false},
+		{"e", "^[\\u00e0-\\u00ff]$", false, nil, false},
+		{"é", "^[^\\u00e0-\\u00ff]$", false, nil, false},
+		{"m", "^[a-fk-pz]$", true, nil, false},
+		{"h", "^[a-fk-pz]$", false, nil, false},
+		{"aaa", "^a{3}$", true, nil, false},
+		{"aa", "^a{3}$", false, nil, false},
+		{"aaaa", "^a{3}$", false, nil, false},
+		{"aaaa", "^a{2,4}$", true, nil, false},
+		{"aaaaa", "^a{2,4}$", false, nil, false},
+		{"a", "^a{2,4}$", false, nil, false},
+		{"aaaaaaa", "^a{2,}$", true, nil, false},
+		{"a", "^a{2,}$", false, nil, false},
+		{"", "^a{0}$", true, nil, false},
+		{"", "^a{0,2}$", true, nil, false},
+		{"ababab", "^(ab){1,3}$", true, nil, false},
+		{"abababab", "^(ab){1,3}$", false, nil, false},
+		{"12-345", "^\\d{2}-\\d{3}$", true, nil, false},
+		{"a{,3}", "a{,3}", true, nil, false},
+		{"x{", "x{", true, nil, false},
+		{"a{1,x}", "a{1,x}", true, nil, false},
+		{"{2}", "{2}$", true, nil, false},
+		{"a", "a{3,2}", false, errors.New("invalid repeat count: {3,2}"), true},
+		{"a", "a{1001}", false, errors.New("invalid repeat count: {1001}"), true},
+		{"a", "a{99999999999999999999}", false, errors.New("invalid repeat count: {99999999999999999999}"), true},
+		{"aaa", "^a+?$", true, nil, false},
+		{"ab", "^a*?b$", true, nil, false},
+		{"ab", "^a??b$", true, nil, false},
+		{"aaaa", "^a{2,4}?$", true, nil, false},
+		{"aaa", "a++a", false, nil, false},
+		{"aaa", "a+a", true, nil, false},
+		{"aaa", "^a*+a", false, nil, false},
+		{"aab", "^a*+b$", true, nil, false},
+		{"a", "^a?+a$", false, nil, false},
+		{"aa", "^a?+a$", true, nil, false},
+		{"aaaa", "^a{2,3}+a$", true, nil, false},
+		{"aaa", "^a{2,3}+a$", false, nil, false},
+		{"xaaab", "(?:a|ab)++b", true, nil, false},
+		{"xabab", "^x(?:ab|a)++b", false, nil, false},
+		{"aaa", "^a+?+$", true, nil, false},
+		{"aaab", "(?>a+)ab", false, nil, false},
+		{"aaab", "(?:a+)ab", true, nil, false},
+		{"aaab", "(?>a+)b", true, nil, false},
+		{"abc", "(?>ab|a)bc", false, nil, false},
+		{"abc", "(?>a|ab)bc", true, nil, false},
+		{"ab", "(?>(a))b", true, nil, false},
+		{"a", "(?>a", false, errors.New("missing closing ')' for '(' at position 0 in \"(?>a\""), true},
+		{"foobar", "foo(?=bar)", true, nil, false},
+		{"foobaz", "foo(?=bar)", false, nil, false},
+		{"foobaz", "foo(?!bar)", true, nil, false},
+		{"foobar", "foo(?!bar)", false, nil, false},
+		{"foo", "foo(?!bar)", true, nil, false},
+		{"ab", "^(?=b)", false, nil, false},
+		{"abc", "a(?=b|x)bc", true, nil, false},
+		{"password1", "^(?=.*\\d)(?=.*[a-z])\\w{8,}$", true, nil, false},
+		{"password", "^(?=.*\\d)(?=.*[a-z])\\w{8,}$", false, nil, false},
+		{"foo", "foo(?=bar", false, errors.New("missing closing ')' for '(' at position 3 in \"foo(?=bar\""), true},
+		{"$5", "(?<=\\$)\\d", true, nil, false},
+		{"5", "(?<=\\$)\\d", false, nil, false},
+		{"€5", "(?<=€)\\d", true, nil, false},
+		{"x5", "(?<!\\$)\\d", true, nil, false},
+		{"$5", "(?<!\\$)\\d", false, nil, false},
+		{"5", "(?<!\\$)\\d", true, nil, false},
+		{"ab", "(?<=a|b)b", true, nil, false},
+		{"aab", "(?<=a{2})b", true, nil, false},
+		{"ab", "(?<=^a)b", true, nil, false},
+		{"cab", "(?<=^a)b", false, nil, false},
+		{"ab", "(?<=ab|b)b", false, errors.New("lookbehind is not of fixed length at position 0 in \"(?<=ab|b)b\""), true},
+		{"ab", "x(?<=a+)b", false, errors.New("lookbehind is not of fixed length at position 1 in \"x(?<=a+)b\""), true},
+		{"ab", "(?<=a{1,2})b", false, errors.New("lookbehind is not of fixed length at position 0 in \"(?<=a{1,2})b\""), true},
+		{"hello hello", "(\\w+) \\1", true, nil, false},
+		{"hello world", "(\\w+) \\1", false, nil, false},
+		{"say the the word", "\\b(\\w+) \\1\\b", true, nil, false},
+		{"abcabc", "^(a)(b)(c)\\1\\2\\3$", true, nil, false},
+		{"abcacb", "^(a)(b)(c)\\1\\2\\3$", false, nil, false},
+		{"abab", "^(a|b)*\\1$", false, nil, false},
+		{"abb", "^(a|b)*\\1$", true, nil, false},
+		{"aaaa", "^(a*)*\\1$", true, nil, false},
+		{"xaxa", "(?:(a)|b)\\1", false, nil, false},
+		{"ab", "(a)?(?(1)b|c)", true, nil, false},
+		{"c", "(a)?(?(1)b|c)", true, nil, false},
+		{"ac", "^(a)?(?(1)b|c)$", false, nil, false},
+		{"b", "^(a)?(?(1)b|c)$", false, nil, false},
+		{"<a>", "^(<)?a(?(1)>)$", true, nil, false},
+		{"a", "^(<)?a(?(1)>)$", true, nil, false},
+		{"<a", "^(<)?a(?(1)>)$", false, nil, false},
+		{"bc", "^(?:(a)|b)+(?(1)b|c)$", true, nil, false},
+		{"ab", "^(a(?(1)x|b))+$", true, nil, false},
+		{"x", "(?(1)a)", false, errors.New("invalid condition: (?(1)"), true},
+		{"x", "(a)(?(x)a)", false, errors.New("invalid condition: (?(x)"), true},
+		{"x", "(a)(?(1", false, errors.New("unclosed condition: (?("), true},
+		{"ab", "(a)(?(1)b|c|d)", false, errors.New("more than two alternatives in conditional group at position 3 in \"(a)(?(1)b|c|d)\""), true},
+		// Nested quantifiers that can match empty strings loop back without consuming input.
+		{"", "(a*)*", true, nil, false},
+		{"", "(a*)+$", true, nil, false},
+		{"b", "^((a?)*)*$", false, nil, false},
+		{"aab", "^(a*|b)*$", true, nil, false},
+		{"", "(?:a*?)*?$", true, nil, false},
+		{"aXa", "(a)(?=X\\1)", true, nil, false},
+		{"a", "(a)\\2", false, errors.New(`invalid backreference: \2 at position 3 in "(a)\\2"`), true},
+		{"a", "\\1(a)", false, errors.New(`invalid backreference: \1 at position 0 in "\\1(a)"`), true},
+		{"٣", "\\d", false, nil, false},
+		{"٣", "(?u)\\d", true, nil, false},
+		{"x", "(?u)\\d", false, nil, false},
+		{"é", "^\\w$", false, nil, false},
+		{"é", "(?u)^\\w$", true, nil, false},
+		{"日本_語2", "(?u)^\\w+$", true, nil, false},
+		{"a-b", "(?u)^\\w+$", false, nil, false},
+		{"é", "((?u)\\w)", true, nil, false},
+		{"本", "\\p{L}", true, nil, false},
+		{"1", "\\p{L}", false, nil, false},
+		{"1", "\\P{L}", true, nil, false},
+		{"本", "\\P{L}", false, nil, false},
+		{"Ω", "^\\p{Lu}$", true, nil, false},
+		{"ω", "^\\p{Lu}$", false, nil, false},
+		{"ω", "^\\pL$", true, nil, false},
+		{"½", "\\p{N}", true, nil, false},
+		{"αβγ", "^\\p{Greek}+$", true, nil, false},
+		{"abc", "\\p{Greek}", false, nil, false},
+		{"a", "\\p{Klingon}", false, errors.New("unknown Unicode property: Klingon"), true},
+		{"a", "\\p{L", false, errors.New("unclosed Unicode property: \\p{"), true},
+		{"a", "\\P", false, errors.New("missing Unicode property name after \\P"), true},
+		{"a", "(ab", false, errors.New(`missing closing ')' for '(' at position 0 in "(ab"`), true},
+		{"a", "ab)", false, errors.New(`unmatched ')' at position 2 in "ab)"`), true},
+		{"a", ")", false, errors.New(`unmatched ')' at position 0 in ")"`), true},
+		{"a", "(a))", false, errors.New(`unmatched ')' at position 3 in "(a))"`), true},
+		{"a", "x((a)", false, errors.New(`missing closing ')' for '(' at position 1 in "x((a)"`), true},
+		{"a", "(?:a", false, errors.New(`missing closing ')' for '(' at position 0 in "(?:a"`), true},
+		{"a", "a|b)", false, errors.New(`unmatched ')' at position 3 in "a|b)"`), true},
+		{"a.b", `\Qa.b\E`, true, nil, false},
+		{"axb", `\Qa.b\E`, false, nil, false},
+		{"(a+)", `\Q(a+)`, true, nil, false},
+		{"aa", `\Q(a+)`, false, nil, false},
+		{"a*bb", `\Qa*\Eb+`, true, nil, false},
+		{"ab", `a\Q\Eb`, true, nil, false},
+		{`\d`, `\Q\d\E`, true, nil, false},
 	}
 
 	for _, tt := range tests {
@@ -100,3 +519,381 @@ func TestMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		pattern string
+		msg     string
+		pos     int
+	}{
+		{"(ab", "missing closing ')' for '('", 0},
+		{"a(b(c)", "missing closing ')' for '('", 1},
+		{"ab)", "unmatched ')'", 2},
+		{"(a)\\2", "invalid backreference: \\2", 3},
+		{"(a)\\9", "invalid backreference: \\9", 3},
+		{"x\\1(a)", "invalid backreference: \\1", 1},
+		{"(a(b)\\3)", "invalid backreference: \\3", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := Compile(tt.pattern)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Compile(%q) error = %v; want a *SyntaxError", tt.pattern, err)
+			}
+			if syntaxErr.Msg != tt.msg || syntaxErr.Pos != tt.pos || syntaxErr.Pattern != tt.pattern {
+				t.Errorf("Compile(%q) error = %#v; want message %q at position %d", tt.pattern, syntaxErr, tt.msg, tt.pos)
+			}
+		})
+	}
+}
+
+func TestWideRangeAllocations(t *testing.T) {
+	// Expanding this range rune by rune used to allocate over a million set entries.
+	allocs := testing.AllocsPerRun(10, func() {
+		MustCompile(`[\x00-\U0010FFFF]+`).MatchString("wide range 😀")
+	})
+	if allocs > 100 {
+		t.Errorf("compiling and matching a wide range made %v allocations; want at most 100", allocs)
+	}
+}
+
+func TestFullMatch(t *testing.T) {
+	tests := []struct {
+		s        string
+		pattern  string
+		expected bool
+	}{
+		{"2024", "\\d\\d\\d\\d", true},
+		{"x2024", "\\d\\d\\d\\d", false},
+		{"2024x", "\\d\\d\\d\\d", false},
+		{"20245", "\\d\\d\\d\\d", false},
+		{"ab", "a|ab", true},
+		{"abc", "(a|ab)(c|bcd)", true},
+		{"", "a*", true},
+		{"abab", "(ab)\\1", true},
+		{"ababab", "(ab)\\1", false},
+		{"", "a+", false},
+		{"a\nb", "(?m)^a$", false},
+		{"a\nb", "(?s)a.b", true},
+		{"user@example.com", "\\w+@\\w+\\.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			result, err := FullMatch(tt.s, tt.pattern)
+			if err != nil || result != tt.expected {
+				t.Errorf("FullMatch(%q, %q) = %v, %v; want %v, nil", tt.s, tt.pattern, result, err, tt.expected)
+			}
+		})
+	}
+
+	if _, err := FullMatch("a", "(a"); err == nil {
+		t.Errorf("FullMatch(%q, %q) returned no error", "a", "(a")
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{"a+", ""},
+		{"(cat|dog)s?", ""},
+		{"", ""},
+		{"[", "unclosed '[' in positive set"},
+		{"[^", "unclosed '[' in negative set"},
+		{"(a", `missing closing ')' for '(' at position 0 in "(a"`},
+		{"a{3,2}", "invalid repeat count: {3,2}"},
+		{"(?:a{1000}){1000}", `expression too large at position 0 in "(?:a{1000}){1000}"`},
+		{"((a{1000}){1000}){1000}", `expression too large at position 0 in "((a{1000}){1000}){1000}"`},
+		{"(?:a{1000}){10}", ""},
+		{`\p{Klingon}`, "unknown Unicode property: Klingon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := Valid(tt.pattern)
+			if tt.err == "" && err != nil {
+				t.Errorf("Valid(%q) = %v; want nil", tt.pattern, err)
+			} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("Valid(%q) = %v; want %q", tt.pattern, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMatchIndex(t *testing.T) {
+	tests := []struct {
+		line    string
+		pattern string
+		start   int
+		end     int
+		matched bool
+	}{
+		{"hello world", "world", 6, 11, true},
+		{"hello world", "o", 4, 5, true},
+		{"hello world", "^hello", 0, 5, true},
+		{"hello world", "d$", 10, 11, true},
+		{"abc", "x*", 0, 0, true},
+		{"abc", "$", 3, 3, true},
+		{"café!", "é", 3, 5, true},
+		{"日本語", "本", 3, 6, true},
+		{"日本語", "[^日]+", 3, 9, true},
+		{"日本語", "語$", 6, 9, true},
+		{"a1b22c", "\\d+", 1, 2, true},
+		{"abc", "xyz", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line+"_"+tt.pattern, func(t *testing.T) {
+			start, end, matched, err := MatchIndex(tt.line, tt.pattern)
+			if err != nil {
+				t.Fatalf("MatchIndex(%q, %q) returned error: %v", tt.line, tt.pattern, err)
+			}
+			if start != tt.start || end != tt.end || matched != tt.matched {
+				t.Errorf("MatchIndex(%q, %q) = %d, %d, %v; want %d, %d, %v",
+					tt.line, tt.pattern, start, end, matched, tt.start, tt.end, tt.matched)
+			}
+		})
+	}
+
+	if _, _, _, err := MatchIndex("a", "[a"); err == nil {
+		t.Errorf("MatchIndex(%q, %q) returned no error", "a", "[a")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tokens, err := Parse("a+")
+	if err != nil {
+		t.Fatalf("Parse(%q) returned error: %v", "a+", err)
+	}
+
+	want := []Token{plusToken{payload: literalToken{char: 'a'}}}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("Parse(%q) = %#v; want %#v", "a+", tokens, want)
+	}
+	if tokens[0].Op() != OpPlus {
+		t.Errorf("Parse(%q)[0].Op() = %v; want %v", "a+", tokens[0].Op(), OpPlus)
+	}
+	if sub := tokens[0].Sub(); len(sub) != 1 || len(sub[0]) != 1 || sub[0][0].Op() != OpLiteral {
+		t.Errorf("Parse(%q)[0].Sub() = %v; want a single literal", "a+", sub)
+	}
+
+	if tokens, err := Parse("\\d\\\\"); err != nil || !reflect.DeepEqual(tokens, []Token{digitToken{}, literalToken{char: '\\'}}) {
+		t.Errorf("Parse(%q) = %#v, %v; want a digit and a backslash", "\\d\\\\", tokens, err)
+	}
+	if _, err := Parse("\\"); err == nil || err.Error() != "trailing backslash in pattern" {
+		t.Errorf("Parse(%q) error = %v; want %q", "\\", err, "trailing backslash in pattern")
+	}
+	if _, err := Parse("[c-a]"); err == nil || err.Error() != "invalid range: c-a" {
+		t.Errorf("Parse(%q) error = %v; want %q", "[c-a]", err, "invalid range: c-a")
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"a+", "a+"},
+		{"\\d\\w*", "\\d\\w*"},
+		{"^ab?$", "^ab?$"},
+		{"[a-c]", "[a-c]"},
+		{"[a-bx]", "[abx]"},
+		{"[\\x00-\\U0010FFFF]", "[\\x{0}-\\x{10FFFF}]"},
+		{"[^[:digit:]_]", "[^0-9_]"},
+		{"[[:^space:]]", "[\\x{0}-\\x{8}\\x{E}-\\x{1F}!-\\x{10FFFF}]"},
+		{"[^a-]", "[^a\\-]"},
+		{"[^-a]", "[^\\-a]"},
+		{"[-a-c]", "[\\-a-c]"},
+		{"[\\]\\x00]", "[\\]\\x{0}]"},
+		{"(cat|dog)", "(cat|dog)"},
+		{"(a|)", "(a|)"},
+		{"(?<year>\\d+)", "(?P<year>\\d+)"},
+		{"(?:ab)+(c)", "(?:ab)+(c)"},
+		{"ab|cd|ef", "(?:ab|cd|ef)"},
+		{"a{2}b{2,}c{2,4}?", "a{2}b{2,}c{2,4}?"},
+		{"a+?b*?c??", "a+?b*?c??"},
+		{"a++b*+c?+d{2}+", "a++b*+c?+d{2}+"},
+		{"a+?+", "a+?+"},
+		{"a(?=b|c)(?!d)", "a(?=b|c)(?!d)"},
+		{"(?>a+|b)c", "(?>a+|b)c"},
+		{"(?<=a|b)(?<!cd)x", "(?<=a|b)(?<!cd)x"},
+		{"(a)(b)\\2\\1", "(a)(b)\\2\\1"},
+		{"a^b$c$", "a\\^b\\$c$"},
+		{"^a|b$", "(?:^a|b$)"},
+		{"|abc", "(?:|abc)"},
+		{"(a)(?(1)b|c)(?(1)d)", "(a)(?(1)b|c)(?(1)d)"},
+		{"\\pL\\P{Greek}", "\\p{L}\\P{Greek}"},
+		{"\\x2E", "\\."},
+		{"\\.\\(\\{", "\\.\\(\\{"},
+		{"\\\\", "\\\\"},
+		{"\\A\\bx\\B\\z", "\\A\\bx\\B\\z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			tokens, err := Parse(tt.pattern)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.pattern, err)
+			}
+
+			if got := joinTokens(tokens); got != tt.expected {
+				t.Errorf("Parse(%q) renders as %q; want %q", tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMatchPathological(t *testing.T) {
+	tests := []struct {
+		line     string
+		pattern  string
+		expected bool
+	}{
+		{strings.Repeat("a", 50), "(a|a)*$", true},
+		{strings.Repeat("a", 50), "(a|a)*b", false},
+		{strings.Repeat("a", 50) + "!", "(a|a)*$", true},
+		{strings.Repeat("a", 50) + "!", "^(a|a)*$", false},
+		{strings.Repeat("a", 50), "(a*)*b", false},
+		{strings.Repeat("a", 50), "(a|aa)+c", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			done := make(chan bool)
+			go func() {
+				result, _ := Match(tt.line, tt.pattern)
+				done <- result
+			}()
+
+			select {
+			case result := <-done:
+				if result != tt.expected {
+					t.Errorf("Match(%q, %q) = %v; want %v", tt.line, tt.pattern, result, tt.expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Match(%q, %q) did not finish in time", tt.line, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestClassesMatchTheirSets(t *testing.T) {
+	tests := []struct{ class, set string }{
+		{`\d`, `[0-9]`},
+		{`\w`, `[a-zA-Z0-9_]`},
+		{`(?u)\d`, `\p{Nd}`},
+		{`(?u)\w`, `(?:\p{L}|\p{N}|_)`},
+	}
+
+	for _, tt := range tests {
+		class, set := MustCompile("^"+tt.class+"$"), MustCompile("^"+tt.set+"$")
+		for r := rune(0); r <= 0x2000; r++ {
+			if got, want := class.MatchString(string(r)), set.MatchString(string(r)); got != want {
+				t.Errorf("%s matches %q = %v; %s matches it = %v", tt.class, r, got, tt.set, want)
+			}
+		}
+	}
+}
+
+func TestHugeRange(t *testing.T) {
+	re := MustCompile(`^[\x00-\uFFFF]+$`)
+	if want := MustCompile(`^a+$`).numStates; re.numStates != want {
+		t.Errorf("[\\x00-\\uFFFF]+ has %d states; want %d, as many as a single rune has", re.numStates, want)
+	}
+
+	var sb strings.Builder
+	for r := rune(1); r <= 0xFFFF; r++ {
+		if utf8.ValidRune(r) {
+			sb.WriteRune(r)
+		}
+	}
+	line := sb.String()
+	if !re.MatchString(line) {
+		t.Errorf("[\\x00-\\uFFFF]+ does not match every rune up to U+FFFF")
+	} else if re.MatchString(line + "\U0001F600") {
+		t.Errorf("[\\x00-\\uFFFF]+ matches U+1F600")
+	}
+}
+
+// BenchmarkCompileClasses compiles a pattern of many classes, whose states test runes with a predicate.
+func BenchmarkCompileClasses(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		MustCompile(`\w\w\w\w\w-\d\d\d`)
+	}
+}
+
+func BenchmarkMatchPathological(b *testing.B) {
+	line := strings.Repeat("a", 50)
+	re := MustCompile("(a|a)*$")
+	for i := 0; i < b.N; i++ {
+		re.MatchString(line)
+	}
+}
+
+// FuzzMatch compares Match with the standard library's regexp package on the syntax both share.
+func FuzzMatch(f *testing.F) {
+	seeds := []struct{ line, pattern string }{
+		{"abc", "a.c"},
+		{"aab", "^a*b$"},
+		{"ab1", "(a|b)+\\d?"},
+		{"a_b", "[^a-c]\\w"},
+		{"", "x*|y"},
+		{"ba", "(^a|b)+$"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.line, seed.pattern)
+	}
+
+	f.Fuzz(func(t *testing.T, line, pattern string) {
+		if !sharedSyntax(pattern) {
+			t.Skip()
+		}
+		want, err := regexp.MatchString(pattern, line)
+		if err != nil {
+			t.Skip()
+		}
+		got, err := Match(line, pattern)
+		if err != nil {
+			t.Fatalf("Match(%q, %q) returned error: %v; regexp.MatchString accepts the pattern", line, pattern, err)
+		}
+		if got != want {
+			t.Errorf("Match(%q, %q) = %v; regexp.MatchString gives %v", line, pattern, got, want)
+		}
+	})
+}
+
+// sharedSyntax reports whether pattern is made of syntax that Match and the standard library treat alike:
+// literals, '.', '*', '+', '?', sets without escapes, alternation, groups, '\d', '\w', and '^' and '$' where
+// they are anchors here, at the start and the end of an alternative.
+func sharedSyntax(pattern string) bool {
+	inSet, setStart := false, 0
+	for i, r := range pattern {
+		switch {
+		case r == '\\':
+			if inSet || i+1 == len(pattern) || pattern[i+1] != 'd' && pattern[i+1] != 'w' {
+				return false
+			}
+		case inSet:
+			// A ']' right after "[" or "[^" is a member of the set.
+			inSet = r != ']' || pattern[setStart:i] == "[" || pattern[setStart:i] == "[^"
+		case r == '[':
+			inSet, setStart = true, i
+		case r == '^':
+			if i > 0 && !strings.ContainsRune("(|", rune(pattern[i-1])) {
+				return false
+			}
+		case r == '$':
+			if i+1 < len(pattern) && !strings.ContainsRune(")|", rune(pattern[i+1])) {
+				return false
+			}
+		case !strings.ContainsRune("abcxyz.*+?|()", r):
+			return false
+		}
+	}
+	return true
+}
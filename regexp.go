@@ -0,0 +1,602 @@
+package re
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrStepLimit is the error returned by MatchStringWithLimit when matching takes more steps than allowed.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// Flags change how CompileFlags compiles a pattern. They may be combined with '|'.
+type Flags uint
+
+const (
+	// Anchored makes every match start at the beginning of the input, as if the pattern began with "\A".
+	// The search then tries the pattern from that position only instead of from every offset in turn.
+	Anchored Flags = 1 << iota
+)
+
+// Regexp is a compiled regular expression.
+// The NFA is built once by Compile and can be matched against many inputs.
+// A Regexp is safe for concurrent use by multiple goroutines, except for configuration methods such as Longest:
+// the NFA is never modified after Compile, and every search keeps its scratch space to itself.
+type Regexp struct {
+	pattern   string
+	tokens    []Token
+	nfa       *nfa
+	searchNfa *nfa
+	anchored  bool
+	numStates int
+	numSubexp int
+	names     []string
+	refs      []int
+	simple    bool
+	longest   bool
+	prefix    string
+	complete  bool
+	required  string
+}
+
+// Compile parses a regular expression and returns, if successful, a Regexp that can be used to match against text.
+// The empty pattern is valid and matches the empty string at every position, so it matches any input
+// but fully matches only the empty string.
+func Compile(pattern string) (*Regexp, error) {
+	return CompileFlags(pattern, 0)
+}
+
+// CompileFlags is like Compile, but compiles the pattern as the flags ask.
+func CompileFlags(pattern string, flags Flags) (*Regexp, error) {
+	p := parser{regexp: pattern}
+	err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := simplify(p.tokens)
+	nfa := buildNfa(tokens)
+	anchored := flags&Anchored != 0
+	searchNfa := nfa.unanchored()
+	if anchored {
+		searchNfa = nfa.anchoredStart()
+	}
+	prefix, complete := literalPrefix(tokens)
+	return &Regexp{
+		pattern:   pattern,
+		tokens:    tokens,
+		nfa:       nfa,
+		searchNfa: searchNfa,
+		anchored:  anchored,
+		numStates: searchNfa.numberStates(),
+		numSubexp: p.numGroups,
+		names:     append([]string{""}, p.names...),
+		refs:      nfa.backrefSlots(),
+		simple:    dfaEligible(searchNfa),
+		prefix:    prefix,
+		complete:  complete,
+		required:  requiredLiteral(tokens),
+	}, nil
+}
+
+// literalPrefix returns the runes of the literal tokens at the start of tokens, and whether tokens has no others.
+func literalPrefix(tokens []Token) (string, bool) {
+	var sb strings.Builder
+	for _, token := range tokens {
+		literal, ok := token.(literalToken)
+		if !ok || !searchableLiteral(literal) {
+			return sb.String(), false
+		}
+		sb.WriteRune(literal.char)
+	}
+	return sb.String(), true
+}
+
+// requiredLiteral returns the longest run of literal tokens that every match must contain: the literals in
+// sequence at the top level of tokens, or inside groups of a single alternative there, which no quantifier or
+// alternation can leave out. A line without it cannot match, which a substring search rules out much faster
+// than the NFA can.
+func requiredLiteral(tokens []Token) string {
+	var longest string
+	var run strings.Builder
+	var scan func(tokens []Token)
+	scan = func(tokens []Token) {
+		for _, token := range tokens {
+			switch t := token.(type) {
+			case literalToken:
+				if searchableLiteral(t) {
+					run.WriteRune(t.char)
+					continue
+				}
+			case groupToken:
+				if len(t.payload) == 1 {
+					scan(t.payload[0])
+					continue
+				}
+			case atomicGroupToken:
+				if len(t.payload) == 1 {
+					scan(t.payload[0])
+					continue
+				}
+			}
+			if run.Len() > len(longest) {
+				longest = run.String()
+			}
+			run.Reset()
+		}
+	}
+	scan(tokens)
+	if run.Len() > len(longest) {
+		longest = run.String()
+	}
+	return longest
+}
+
+// searchableLiteral reports whether a substring search can look for the literal token as it is. U+FFFD cannot be:
+// the matcher decodes every invalid byte of the input to it, so it matches bytes that do not spell it out.
+func searchableLiteral(t literalToken) bool {
+	return t.char != utf8.RuneError
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be parsed.
+func MustCompile(pattern string) *Regexp {
+	re, err := Compile(pattern)
+	if err != nil {
+		panic("re: Compile(" + strconv.Quote(pattern) + "): " + err.Error())
+	}
+	return re
+}
+
+// QuoteMeta returns a string that escapes all regular expression metacharacters inside the argument text;
+// the returned string is a regular expression matching the literal text.
+func QuoteMeta(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(metaChars, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// String returns the source text used to compile the regular expression.
+func (re *Regexp) String() string {
+	return re.pattern
+}
+
+// Equal reports whether re and other are the same regular expression: their patterns parse to the same tokens,
+// and they match in the same mode, anchored or not and leftmost-first or leftmost-longest. Patterns that are
+// written differently can be equal, like "\\x61+" and "a+", or "a**" and "a*" since nested quantifiers are
+// collapsed, while patterns that match the same strings by different means, like "aa" and "a{2}", are not.
+func (re *Regexp) Equal(other *Regexp) bool {
+	return reflect.DeepEqual(re.tokens, other.tokens) && re.anchored == other.anchored && re.longest == other.longest
+}
+
+// LiteralPrefix returns a literal string that every match of the regular expression begins with, and whether
+// the whole regular expression is that literal string, so that a match is just an occurrence of it.
+// A line without the prefix cannot contain a match, which a quick substring search can rule out.
+func (re *Regexp) LiteralPrefix() (string, bool) {
+	return re.prefix, re.complete
+}
+
+// NumSubexp returns the number of parenthesized capturing subexpressions in the regular expression.
+// Non-capturing groups, atomic groups and lookaround assertions are not counted.
+func (re *Regexp) NumSubexp() int {
+	return re.numSubexp
+}
+
+// SubexpNames returns the names of the capturing groups, as given by "(?P<name>...)" or "(?<name>...)".
+// The name of group i is SubexpNames()[i]; the first element stands for the whole match and is always "",
+// as is the name of an unnamed group. The slice must not be modified.
+func (re *Regexp) SubexpNames() []string {
+	return re.names
+}
+
+// SubexpIndex returns the number of the capturing group with the given name, or -1 if there is no such group.
+// The offsets of the group in the result of FindStringSubmatchIndex are at 2*i and 2*i+1.
+func (re *Regexp) SubexpIndex(name string) int {
+	if name != "" {
+		for i, groupName := range re.names {
+			if groupName == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Copy returns a new Regexp that shares the compiled NFA of re, which is never modified, but can be configured
+// independently, so calling Longest on the copy leaves re as it is. Matching needs no copy: every search keeps
+// its scratch space to itself, and a Regexp can be used by any number of goroutines at once.
+func (re *Regexp) Copy() *Regexp {
+	copied := *re
+	return &copied
+}
+
+// Longest makes future searches prefer leftmost-longest matches, as POSIX does: among the matches that start
+// leftmost, the one that extends furthest is chosen. By default the first match found is chosen instead,
+// with alternatives tried from left to right and quantifiers repeating as often as possible, as in Perl.
+// For example, "a|ab" finds "a" in "ab" by default and "ab" in leftmost-longest mode.
+// This method modifies the Regexp and must not be called concurrently with any other method.
+func (re *Regexp) Longest() {
+	re.longest = true
+}
+
+// MatchString reports whether the string s contains any match of the regular expression.
+// The match may start at any position in s. A string without the literal text that every match contains, like
+// "error" for "\\d+ error", is rejected by a substring search without running the NFA.
+func (re *Regexp) MatchString(s string) bool {
+	if !strings.Contains(s, re.required) {
+		return false
+	}
+	return re.find(&machine{}, stringSource(s), 0, 2) != nil
+}
+
+// Match reports whether the byte slice b contains any match of the regular expression.
+// Like MatchString, it rejects a slice without the literal text that every match contains before running the NFA.
+func (re *Regexp) Match(b []byte) bool {
+	if !bytes.Contains(b, []byte(re.required)) {
+		return false
+	}
+	return re.find(&machine{}, bytesSource(b), 0, 2) != nil
+}
+
+// FindIndex returns the start and end byte offsets of the leftmost match in b, so that b[loc[0]:loc[1]]
+// is the matched text. It returns nil if there is no match.
+func (re *Regexp) FindIndex(b []byte) []int {
+	return re.find(&machine{}, bytesSource(b), 0, 2)
+}
+
+// MatchStringContext is like MatchString, but gives up and returns the error of ctx once ctx is done.
+// The context is checked periodically while matching, so a deadline bounds the time spent on a pattern
+// that is slow on some input.
+func (re *Regexp) MatchStringContext(ctx context.Context, s string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m := &machine{ctx: ctx}
+	if !re.search(m, stringSource(s), 0, make([]int, 2)) {
+		return false, m.err
+	}
+	return true, nil
+}
+
+// MatchStringWithLimit is like MatchString, but gives up and returns ErrStepLimit once matching has taken
+// more than maxSteps steps, each following one transition of the NFA. Unlike a deadline, the bound does not
+// depend on the speed of the machine, so the same pattern and input always give the same result.
+// A maxSteps of zero or less means no limit.
+func (re *Regexp) MatchStringWithLimit(s string, maxSteps int) (bool, error) {
+	m := &machine{maxSteps: maxSteps}
+	if !re.search(m, stringSource(s), 0, make([]int, 2)) {
+		return false, m.err
+	}
+	return true, nil
+}
+
+// MatchStringAnchored reports whether the regular expression matches a prefix of s.
+// Unlike MatchString, the match must start at the beginning of s.
+func (re *Regexp) MatchStringAnchored(s string) bool {
+	input := stringSource(s)
+	m := &machine{refs: re.refs}
+	m.visits.reset(re.numStates, len(input))
+	_, ok := m.matches(re.nfa, input, bosWidth, nil, false)
+	return ok
+}
+
+// MatchAt reports whether the regular expression matches s starting exactly at byte offset pos, and if so
+// returns the offset where the match ends. Assertions see the whole of s, so "^" only matches at offset 0
+// and "\\b" looks at the rune before pos. This lets a tokenizer try patterns at successive offsets
+// without slicing s. An offset outside s never matches.
+func (re *Regexp) MatchAt(s string, pos int) (int, bool) {
+	if pos < 0 || pos > len(s) {
+		return 0, false
+	}
+
+	input := stringSource(s)
+	m := &machine{refs: re.refs}
+	m.visits.reset(re.numStates, len(input))
+	end, ok := m.matches(re.nfa, input, pos+bosWidth, nil, re.longest)
+	if !ok {
+		return 0, false
+	}
+	return end - bosWidth, true
+}
+
+// FindString returns the text of the leftmost match in s, or an empty string if there is no match.
+// It cannot tell an empty match from no match; use FindStringIndex for that.
+func (re *Regexp) FindString(s string) string {
+	loc := re.FindStringIndex(s)
+	if loc == nil {
+		return ""
+	}
+	return s[loc[0]:loc[1]]
+}
+
+// FindStringIndex returns the start and end byte offsets of the leftmost match in s, so that s[loc[0]:loc[1]]
+// is the matched text. It returns nil if there is no match.
+func (re *Regexp) FindStringIndex(s string) []int {
+	return re.find(&machine{}, stringSource(s), 0, 2)
+}
+
+// FindAllStringIndex returns the start and end byte offsets in s of successive non-overlapping matches
+// of the regular expression. If n >= 0, it returns at most n matches; otherwise it returns all of them.
+// An empty match directly after the previous match is ignored, and the search moves on by one rune
+// after any empty match, so zero-width patterns cannot loop forever.
+func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
+	return re.findAll(s, n, 2)
+}
+
+// FindAllString returns the text of successive non-overlapping matches in s, as reported by FindAllStringIndex.
+// It returns nil if there is no match.
+func (re *Regexp) FindAllString(s string, n int) []string {
+	var matches []string
+	for _, loc := range re.FindAllStringIndex(s, n) {
+		matches = append(matches, s[loc[0]:loc[1]])
+	}
+	return matches
+}
+
+// FindStringSubmatchIndex returns the byte offsets of the leftmost match in s and of each capturing group
+// within it. Group i spans result[2*i:2*i+2]; a group that did not take part in the match has offsets -1.
+// It returns nil if there is no match.
+func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	return re.find(&machine{}, stringSource(s), 0, re.numCaps())
+}
+
+// FindStringSubmatch returns the text of the leftmost match in s and of each capturing group within it.
+// A group that did not take part in the match yields an empty string. It returns nil if there is no match.
+func (re *Regexp) FindStringSubmatch(s string) []string {
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+
+	submatches := make([]string, len(loc)/2)
+	for i := range submatches {
+		if loc[2*i] >= 0 {
+			submatches[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return submatches
+}
+
+// FindAllStringSubmatchIndex is the 'All' version of FindStringSubmatchIndex, returning the submatch offsets
+// of successive non-overlapping matches like FindAllStringIndex.
+func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	return re.findAll(s, n, re.numCaps())
+}
+
+// Split slices s into substrings separated by matches of the regular expression and returns the substrings
+// between them, like strings.Split. If n > 0, at most n substrings are returned and the last one is the
+// unsplit remainder; if n == 0, the result is nil; if n < 0, all substrings are returned.
+// A match at the beginning or end of s yields an empty first or last substring. An empty match only splits
+// between runes, so an empty pattern splits s into its runes.
+func (re *Regexp) Split(s string, n int) []string {
+	if n == 0 {
+		return nil
+	} else if re.pattern != "" && s == "" {
+		return []string{""}
+	}
+
+	matches := re.FindAllStringIndex(s, n)
+	substrings := make([]string, 0, len(matches)+1)
+	begin, end := 0, 0
+	for _, match := range matches {
+		if n > 0 && len(substrings) == n-1 {
+			break
+		}
+
+		end = match[0]
+		if match[1] != 0 {
+			substrings = append(substrings, s[begin:end])
+		}
+		begin = match[1]
+	}
+
+	if end != len(s) {
+		substrings = append(substrings, s[begin:])
+	}
+	return substrings
+}
+
+// ReplaceAllStringFunc returns a copy of src in which every match of the regular expression has been replaced
+// by the return value of repl applied to the matched substring. The function is called once per match,
+// from left to right, for the same non-overlapping matches that FindAllStringIndex reports.
+func (re *Regexp) ReplaceAllStringFunc(src string, repl func(string) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringIndex(src, -1) {
+		sb.WriteString(src[last:match[0]])
+		sb.WriteString(repl(src[match[0]:match[1]]))
+		last = match[1]
+	}
+	sb.WriteString(src[last:])
+	return sb.String()
+}
+
+// ReplaceAllString returns a copy of src in which every match of the regular expression has been replaced
+// by the template repl. Inside repl, $1 or ${1} stands for the text of the first capturing group, $name or
+// ${name} for that of the group named name, and $$ for a literal dollar sign. A variable name is the longest
+// run of letters, digits and underscores, so "$1x" refers to a group named "1x"; write "${1}x" instead.
+// References to groups that do not exist or did not take part in the match expand to the empty string.
+func (re *Regexp) ReplaceAllString(src, repl string) string {
+	return re.ReplaceStringN(src, repl, -1)
+}
+
+// ReplaceStringN is like ReplaceAllString, but replaces only the first n matches, as sed's s/re/repl/n count
+// does for a line. If n < 0, every match is replaced; if n == 0, src is returned unchanged.
+func (re *Regexp) ReplaceStringN(src, repl string, n int) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(src, n) {
+		sb.WriteString(src[last:match[0]])
+		re.expand(&sb, repl, src, match)
+		last = match[1]
+	}
+	sb.WriteString(src[last:])
+	return sb.String()
+}
+
+// expand appends the template to sb, replacing variables with the submatches of src described by match.
+func (re *Regexp) expand(sb *strings.Builder, template, src string, match []int) {
+	for len(template) > 0 {
+		before, after, found := strings.Cut(template, "$")
+		sb.WriteString(before)
+		if !found {
+			break
+		}
+
+		template = after
+		if strings.HasPrefix(template, "$") {
+			sb.WriteByte('$')
+			template = template[1:]
+			continue
+		}
+
+		name, rest, ok := extractVariable(template)
+		if !ok {
+			// Not a valid variable: keep the dollar sign as written.
+			sb.WriteByte('$')
+			continue
+		}
+		template = rest
+
+		// A name that is not a number refers to the named group, if there is one.
+		i, err := strconv.Atoi(name)
+		if err != nil {
+			i = re.SubexpIndex(name)
+		}
+		if i >= 0 && 2*i+1 < len(match) && match[2*i] >= 0 {
+			sb.WriteString(src[match[2*i]:match[2*i+1]])
+		}
+	}
+}
+
+// extractVariable parses the variable name at the start of template, which follows a '$'.
+// The name is either the longest run of letters, digits and underscores, or the text between '{' and '}'.
+// It returns the name and the rest of the template, or false if there is no valid variable.
+func extractVariable(template string) (name, rest string, ok bool) {
+	braced := strings.HasPrefix(template, "{")
+	if braced {
+		template = template[1:]
+	}
+
+	i := 0
+	for i < len(template) && isWordChar(rune(template[i])) {
+		i++
+	}
+	if i == 0 {
+		return "", "", false
+	}
+
+	name, rest = template[:i], template[i:]
+	if braced {
+		if !strings.HasPrefix(rest, "}") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	}
+	return name, rest, true
+}
+
+// numCaps returns the length of a capture slice holding the whole match and every capturing group.
+func (re *Regexp) numCaps() int {
+	return 2 * (re.numSubexp + 1)
+}
+
+// bosWidth is the byte width of the BOS character, and so the offset of the original input in a prepared string.
+var bosWidth = utf8.RuneLen(BOS)
+
+// find returns the byte offsets of the leftmost match in the original string that starts at or after offset from,
+// or nil if there is none, using the scratch space of m. The input is the prepared form of the string. The result
+// holds ncap offsets: the whole match, followed by the capturing groups if ncap is large enough to include them.
+// A caller looking for successive matches in the same input passes the same m each time, so that the memory
+// of the search is allocated once rather than for every match.
+func (re *Regexp) find(m *machine, input string, from, ncap int) []int {
+	caps := make([]int, max(ncap, 2))
+	if !re.search(m, input, from, caps) {
+		return nil
+	}
+
+	for i := range caps {
+		if caps[i] >= 0 {
+			caps[i] -= bosWidth
+		}
+	}
+	return caps
+}
+
+// search looks for the leftmost match in the prepared input that starts at or after offset from of the original
+// string, or only at offset 0 if the Regexp is anchored, using the scratch space of m. On success it reports true
+// and fills caps, which must hold at least the two offsets of the whole match, with offsets into the prepared
+// input. If the context of m is done, or it takes more than the steps m allows, it reports false and leaves
+// the error in m.err.
+func (re *Regexp) search(m *machine, input string, from int, caps []int) bool {
+	for i := range caps {
+		caps[i] = -1
+	}
+	if re.anchored && from > 0 {
+		return false
+	}
+
+	m.refs = re.refs
+	m.visits.reset(re.numStates, len(input))
+	end, ok := m.matches(re.searchNfa, input, from+bosWidth, caps, false)
+	if !ok {
+		return false
+	}
+
+	// The leftmost match starts at the same position whichever match is preferred there,
+	// so the longest one is searched for from that position only.
+	if re.longest {
+		start := caps[0]
+		for i := range caps {
+			caps[i] = -1
+		}
+		caps[0] = start
+		m.visits.reset(re.numStates, len(input))
+		end, ok = m.matches(re.nfa, input, start, caps, true)
+		if !ok {
+			return false
+		}
+	}
+
+	caps[1] = end
+	return true
+}
+
+// findAll returns the offsets of successive non-overlapping matches in s, as described for FindAllStringIndex.
+// Each match holds ncap offsets, as described for find.
+func (re *Regexp) findAll(s string, n, ncap int) [][]int {
+	input := stringSource(s)
+	m := &machine{}
+	var matches [][]int
+	prevEnd := -1
+	for pos := 0; pos <= len(s) && (n < 0 || len(matches) < n); {
+		loc := re.find(m, input, pos, ncap)
+		if loc == nil {
+			break
+		}
+
+		if loc[1] > loc[0] || loc[0] != prevEnd {
+			matches = append(matches, loc)
+			prevEnd = loc[1]
+		}
+
+		if loc[1] > loc[0] {
+			pos = loc[1]
+		} else if loc[0] < len(s) {
+			_, runeSize := utf8.DecodeRuneInString(s[loc[0]:])
+			pos = loc[0] + runeSize
+		} else {
+			break
+		}
+	}
+	return matches
+}
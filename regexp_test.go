@@ -0,0 +1,887 @@
+package re
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchStringAnchored(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		s          string
+		unanchored bool
+		anchored   bool
+	}{
+		{"a", "ba", true, false},
+		{"a", "ab", true, true},
+		{"^a", "ab", true, true},
+		{"b", "ab", true, false},
+		{"\\d+", "123abc", true, true},
+		{"\\d+", "abc123", true, false},
+		{"abc$", "abc", true, true},
+		{"abc$", "abcd", false, false},
+		{"", "abc", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if got := re.MatchString(tt.s); got != tt.unanchored {
+				t.Errorf("MustCompile(%q).MatchString(%q) = %v; want %v", tt.pattern, tt.s, got, tt.unanchored)
+			}
+			if got := re.MatchStringAnchored(tt.s); got != tt.anchored {
+				t.Errorf("MustCompile(%q).MatchStringAnchored(%q) = %v; want %v", tt.pattern, tt.s, got, tt.anchored)
+			}
+
+			anchored, err := CompileFlags(tt.pattern, Anchored)
+			if err != nil {
+				t.Fatalf("CompileFlags(%q, Anchored) returned error: %v", tt.pattern, err)
+			}
+			if got := anchored.MatchString(tt.s); got != tt.anchored {
+				t.Errorf("CompileFlags(%q, Anchored).MatchString(%q) = %v; want %v", tt.pattern, tt.s, got, tt.anchored)
+			}
+			if got := anchored.NewMatcher().MatchString(tt.s); got != tt.anchored {
+				t.Errorf("CompileFlags(%q, Anchored).NewMatcher().MatchString(%q) = %v; want %v", tt.pattern, tt.s, got, tt.anchored)
+			}
+		})
+	}
+}
+
+func TestMatchAt(t *testing.T) {
+	// Tokenize an expression by trying each pattern at the offset where the previous token ended.
+	patterns := []*Regexp{MustCompile("\\d+"), MustCompile("[-+*/]"), MustCompile(" +")}
+	s := "12+34 * 5"
+	var tokens []string
+	for pos := 0; pos < len(s); {
+		matched := false
+		for _, re := range patterns {
+			if end, ok := re.MatchAt(s, pos); ok && end > pos {
+				tokens = append(tokens, s[pos:end])
+				pos, matched = end, true
+				break
+			}
+		}
+		if !matched {
+			t.Fatalf("no pattern matches %q at offset %d", s, pos)
+		}
+	}
+	if want := []string{"12", "+", "34", " ", "*", " ", "5"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens = %q; want %q", tokens, want)
+	}
+
+	tests := []struct {
+		pattern string
+		s       string
+		pos     int
+		end     int
+		ok      bool
+	}{
+		{"b+", "abbc", 1, 3, true},
+		{"b+", "abbc", 0, 0, false},
+		{"^b", "ab", 1, 0, false},
+		{"\\bb", "ab", 1, 0, false},
+		{"\\bb", "a b", 2, 3, true},
+		{"c$", "abc", 2, 3, true},
+		{"", "abc", 3, 3, true},
+		{"a", "abc", 4, 0, false},
+		{"a", "abc", -1, 0, false},
+		{"(b)\\1", "abb", 1, 3, true},
+	}
+	for _, tt := range tests {
+		end, ok := MustCompile(tt.pattern).MatchAt(tt.s, tt.pos)
+		if end != tt.end || ok != tt.ok {
+			t.Errorf("MustCompile(%q).MatchAt(%q, %d) = %d, %v; want %d, %v", tt.pattern, tt.s, tt.pos, end, ok, tt.end, tt.ok)
+		}
+	}
+
+	longest := MustCompile("a|ab")
+	longest.Longest()
+	if end, ok := longest.MatchAt("xab", 1); end != 3 || !ok {
+		t.Errorf("MatchAt in leftmost-longest mode = %d, %v; want 3, true", end, ok)
+	}
+}
+
+func TestCompileFlagsAnchored(t *testing.T) {
+	re, err := CompileFlags("(\\d)+", Anchored)
+	if err != nil {
+		t.Fatalf("CompileFlags returned error: %v", err)
+	}
+	if got := re.FindStringSubmatchIndex("12a3"); !reflect.DeepEqual(got, []int{0, 2, 1, 2}) {
+		t.Errorf("FindStringSubmatchIndex(%q) = %v; want [0 2 1 2]", "12a3", got)
+	}
+	// Only the match at the beginning of the input counts; the later one does not start there.
+	if got := re.FindAllStringIndex("12a3", -1); !reflect.DeepEqual(got, [][]int{{0, 2}}) {
+		t.Errorf("FindAllStringIndex(%q) = %v; want [[0 2]]", "12a3", got)
+	}
+	if got := re.FindStringIndex("a12"); got != nil {
+		t.Errorf("FindStringIndex(%q) = %v; want nil", "a12", got)
+	}
+	if _, err := CompileFlags("(", Anchored); err == nil {
+		t.Errorf("CompileFlags(%q, Anchored) returned no error", "(")
+	}
+}
+
+func TestRegexpConcurrent(t *testing.T) {
+	re := MustCompile(`(\w+)@(\w+)\.com`)
+	lines := []string{"mail alice@example.com now", "no address here", "bob@test.com", "x@y.org"}
+	want := make([][]string, len(lines))
+	for i, line := range lines {
+		want[i] = re.FindStringSubmatch(line)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				j := (g + i) % len(lines)
+				if got := re.MatchString(lines[j]); got != (want[j] != nil) {
+					errs <- fmt.Sprintf("MatchString(%q) = %v", lines[j], got)
+					return
+				}
+				if got := re.FindStringSubmatch(lines[j]); !reflect.DeepEqual(got, want[j]) {
+					errs <- fmt.Sprintf("FindStringSubmatch(%q) = %q; want %q", lines[j], got, want[j])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	re := MustCompile("(a|ab)c?")
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Half of the goroutines configure their copy for leftmost-longest matching, which must not
+			// affect the others or the original.
+			copied, want := re.Copy(), "a"
+			if g%2 == 0 {
+				copied.Longest()
+				want = "abc"
+			}
+			for i := 0; i < 200; i++ {
+				if got := copied.FindString("xabc"); got != want {
+					errs <- fmt.Sprintf("copy %d: FindString = %q; want %q", g, got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := re.FindString("xabc"); got != "a" {
+		t.Errorf("original FindString = %q after configuring copies; want %q", got, "a")
+	}
+}
+
+func TestMustCompilePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustCompile(%q) did not panic", "[c-a]")
+		}
+	}()
+	MustCompile("[c-a]")
+}
+
+func TestQuoteMeta(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"a.b", `a\.b`},
+		{"abc", "abc"},
+		{"", ""},
+		{`1+1=2? (maybe) [x] {y} ^$|\*`, `1\+1=2\? \(maybe\) \[x\] \{y\} \^\$\|\\\*`},
+		{"héllo.", `héllo\.`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			quoted := QuoteMeta(tt.s)
+			if quoted != tt.expected {
+				t.Errorf("QuoteMeta(%q) = %q; want %q", tt.s, quoted, tt.expected)
+			}
+
+			if loc := MustCompile(quoted).FindAllStringIndex(tt.s, -1); len(tt.s) > 0 && !reflect.DeepEqual(loc, [][]int{{0, len(tt.s)}}) {
+				t.Errorf("QuoteMeta(%q) matches %v; want the whole string", tt.s, loc)
+			}
+		})
+	}
+
+	if ok, err := Match("a.b", QuoteMeta("a.b")); !ok || err != nil {
+		t.Errorf("Match(%q, QuoteMeta(%q)) = %v, %v; want true, nil", "a.b", "a.b", ok, err)
+	}
+	if ok, _ := Match("axb", QuoteMeta("a.b")); ok {
+		t.Errorf("Match(%q, QuoteMeta(%q)) = true; want false", "axb", "a.b")
+	}
+}
+
+func TestRegexpString(t *testing.T) {
+	re := MustCompile("a+b")
+	if got := re.String(); got != "a+b" {
+		t.Errorf("MustCompile(%q).String() = %q; want %q", "a+b", got, "a+b")
+	}
+
+	var _ fmt.Stringer = re
+	if got := fmt.Sprint(re); got != "a+b" {
+		t.Errorf("fmt.Sprint(MustCompile(%q)) = %q; want %q", "a+b", got, "a+b")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"a+", "a+", true},
+		{"a+", "a*", false},
+		{"\\x61+", "a+", true},
+		{"a**", "(?:a+)?", true},
+		{"[a-c]", "[abc]", false},
+		{"(?:a)", "a", false},
+		{"aa", "a{2}", false},
+		{"(a)\\1", "(a)\\1", true},
+		{"(?m)^a", "^a", false},
+		{"a|b", "(?:a|b)", true},
+	}
+
+	for _, tt := range tests {
+		if got := MustCompile(tt.a).Equal(MustCompile(tt.b)); got != tt.equal {
+			t.Errorf("MustCompile(%q).Equal(MustCompile(%q)) = %v; want %v", tt.a, tt.b, got, tt.equal)
+		}
+	}
+
+	longest := MustCompile("a|ab")
+	longest.Longest()
+	if MustCompile("a|ab").Equal(longest) {
+		t.Errorf("a leftmost-first Regexp is Equal to a leftmost-longest one")
+	}
+	anchored, _ := CompileFlags("a", Anchored)
+	if MustCompile("a").Equal(anchored) {
+		t.Errorf("an unanchored Regexp is Equal to an anchored one")
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		prefix   string
+		complete bool
+	}{
+		{"abc", "abc", true},
+		{"", "", true},
+		{"ab+c", "a", false},
+		{"ab*", "a", false},
+		{"\\.\\x41日本", ".A日本", true},
+		{"ab\\d", "ab", false},
+		{"^ab", "", false},
+		{"ab|ac", "", false},
+		{"(ab)c", "", false},
+		{"a\\x{FFFD}b", "a", false},
+	}
+
+	for _, tt := range tests {
+		prefix, complete := MustCompile(tt.pattern).LiteralPrefix()
+		if prefix != tt.prefix || complete != tt.complete {
+			t.Errorf("MustCompile(%q).LiteralPrefix() = %q, %v; want %q, %v", tt.pattern, prefix, complete, tt.prefix, tt.complete)
+		}
+	}
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"abc", "abc"},
+		{"\\d+ error", " error"},
+		{"ab\\d+error", "error"},
+		{"a(bc)d", "abcd"},
+		{"a(?:b|c)d", "a"},
+		{"x+yz?", "y"},
+		{"(?>ab)c[0-9]", "abc"},
+		{"a|b", ""},
+		{"(?=abc)x", "x"},
+		{"ab\\x{FFFD}cde", "cde"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := MustCompile(tt.pattern).required; got != tt.want {
+			t.Errorf("MustCompile(%q).required = %q; want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// TestRequiredLiteralSkipsNFA checks that every entry point that reports whether there is a match rejects
+// an input lacking the required literal without running the NFA, by requiring a literal the NFA does not need.
+func TestRequiredLiteralSkipsNFA(t *testing.T) {
+	re := MustCompile("a+")
+	re.required = "b"
+	input := "aaa"
+
+	if re.MatchString(input) {
+		t.Errorf("MatchString(%q) = true with required literal %q; want false", input, re.required)
+	}
+	if re.Match([]byte(input)) {
+		t.Errorf("Match(%q) = true with required literal %q; want false", input, re.required)
+	}
+	if re.NewMatcher().MatchString(input) {
+		t.Errorf("Matcher.MatchString(%q) = true with required literal %q; want false", input, re.required)
+	}
+	if !re.Match([]byte("aab")) {
+		t.Errorf("Match(%q) = false; want true", "aab")
+	}
+}
+
+func TestNumSubexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    int
+	}{
+		{"abc", 0},
+		{"(a)(b)(?:c)", 2},
+		{"((a)|b)+", 2},
+		{"(?>a)(?=b)(?<!c)(d)", 1},
+		{"\\(a\\)[(]", 0},
+	}
+
+	for _, tt := range tests {
+		if got := MustCompile(tt.pattern).NumSubexp(); got != tt.want {
+			t.Errorf("MustCompile(%q).NumSubexp() = %d; want %d", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestSubexpNames(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"abc", []string{""}},
+		{"(a)(?P<b>b)(?:c)", []string{"", "", "b"}},
+		{"(?<first>a)((?P<second>b)|c)", []string{"", "first", "", "second"}},
+	}
+
+	for _, tt := range tests {
+		if got := MustCompile(tt.pattern).SubexpNames(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MustCompile(%q).SubexpNames() = %q; want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestSubexpIndex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    int
+	}{
+		{"(?P<year>\\d{4})", "year", 1},
+		{"(?P<year>\\d{4})", "nope", -1},
+		{"(?P<year>\\d{4})", "", -1},
+		{"(\\d{4})-(?<month>\\d{2})", "month", 2},
+		{"(\\d{4})", "", -1},
+	}
+
+	for _, tt := range tests {
+		if got := MustCompile(tt.pattern).SubexpIndex(tt.name); got != tt.want {
+			t.Errorf("MustCompile(%q).SubexpIndex(%q) = %d; want %d", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	re := MustCompile("(?P<year>\\d{4})-(?P<month>\\d{2})")
+	loc := re.FindStringSubmatchIndex("on 2024-05")
+	if i := re.SubexpIndex("month"); !reflect.DeepEqual(loc[2*i:2*i+2], []int{8, 10}) {
+		t.Errorf("month offsets = %v; want [8 10]", loc[2*i:2*i+2])
+	}
+}
+
+func TestFindAllStringIndex(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		n        int
+		expected [][]int
+	}{
+		{"\\d+", "a1b22c", -1, [][]int{{1, 2}, {3, 5}}},
+		{"\\d+", "a1b22c", 1, [][]int{{1, 2}}},
+		{"\\d+", "abc", -1, nil},
+		{"^a", "aaa", -1, [][]int{{0, 1}}},
+		{"a$", "aaa", -1, [][]int{{2, 3}}},
+		{"a*", "baaac", -1, [][]int{{0, 0}, {1, 4}, {5, 5}}},
+		{"", "ab", -1, [][]int{{0, 0}, {1, 1}, {2, 2}}},
+		{"", "", -1, [][]int{{0, 0}}},
+		{"本", "日本語本", -1, [][]int{{3, 6}, {9, 12}}},
+		{"", "日本", -1, [][]int{{0, 0}, {3, 3}, {6, 6}}},
+		{"x*", "日x", -1, [][]int{{0, 0}, {3, 4}}},
+		{"\\b", "ab cd", -1, [][]int{{0, 0}, {2, 2}, {3, 3}, {5, 5}}},
+		{"\\d+", "a1b22c", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			got := MustCompile(tt.pattern).FindAllStringIndex(tt.s, tt.n)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MustCompile(%q).FindAllStringIndex(%q, %d) = %v; want %v", tt.pattern, tt.s, tt.n, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBytes(t *testing.T) {
+	patterns := []string{"a", "b+", "^ab", "c$", "本", "[^a]", "(?m)^c", "", "x*", "\\bbc"}
+	inputs := []string{"", "abc", "bbb", "a\nc", "日本語", "\x02a\x03", "\xffc"}
+
+	for _, pattern := range patterns {
+		re := MustCompile(pattern)
+		for _, s := range inputs {
+			if got, want := re.Match([]byte(s)), re.MatchString(s); got != want {
+				t.Errorf("MustCompile(%q).Match(%q) = %v; want %v", pattern, s, got, want)
+			}
+			if got, want := re.FindIndex([]byte(s)), re.FindStringIndex(s); !reflect.DeepEqual(got, want) {
+				t.Errorf("MustCompile(%q).FindIndex(%q) = %v; want %v", pattern, s, got, want)
+			}
+		}
+	}
+
+	if re := MustCompile("b+"); re.FindIndex(nil) != nil || re.Match(nil) {
+		t.Errorf("MustCompile(%q) matches a nil slice", "b+")
+	}
+}
+
+func TestFindAllString(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		n        int
+		expected []string
+	}{
+		{"\\d+", "a1b22c", -1, []string{"1", "22"}},
+		{"\\d+", "a1b22c", 1, []string{"1"}},
+		{"\\d+", "abc", -1, nil},
+		{"a*", "baaac", -1, []string{"", "aaa", ""}},
+		{"", "aé", -1, []string{"", "", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			got := MustCompile(tt.pattern).FindAllString(tt.s, tt.n)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MustCompile(%q).FindAllString(%q, %d) = %q; want %q", tt.pattern, tt.s, tt.n, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEmptyPattern(t *testing.T) {
+	for _, s := range []string{"", "a", "aé"} {
+		if matched, err := Match(s, ""); !matched || err != nil {
+			t.Errorf("Match(%q, %q) = %v, %v; want true, nil", s, "", matched, err)
+		}
+		if matched, err := FullMatch(s, ""); matched != (s == "") || err != nil {
+			t.Errorf("FullMatch(%q, %q) = %v, %v; want %v, nil", s, "", matched, err, s == "")
+		}
+	}
+
+	re := MustCompile("")
+	if loc := re.FindStringIndex("abc"); !reflect.DeepEqual(loc, []int{0, 0}) {
+		t.Errorf("FindStringIndex(%q) = %v; want [0 0]", "abc", loc)
+	}
+	if !re.MatchStringAnchored("abc") {
+		t.Errorf("MatchStringAnchored(%q) = false; want true", "abc")
+	}
+	// An empty match at every rune boundary: after each one, the search moves on by a whole rune.
+	if locs := re.FindAllStringIndex("aé", -1); !reflect.DeepEqual(locs, [][]int{{0, 0}, {1, 1}, {3, 3}}) {
+		t.Errorf("FindAllStringIndex(%q) = %v; want [[0 0] [1 1] [3 3]]", "aé", locs)
+	}
+	if got := re.ReplaceAllString("aé", "-"); got != "-a-é-" {
+		t.Errorf("ReplaceAllString(%q) = %q; want %q", "aé", got, "-a-é-")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		n        int
+		expected []string
+	}{
+		{"\\d", "a1b2c", -1, []string{"a", "b", "c"}},
+		{"\\d", "a1b2c", 2, []string{"a", "b2c"}},
+		{"\\d", "a1b2c", 0, nil},
+		{"\\d", "1a1", -1, []string{"", "a", ""}},
+		{"\\d+", "abc", -1, []string{"abc"}},
+		{"\\d", "", -1, []string{""}},
+		{"", "abc", -1, []string{"a", "b", "c"}},
+		{"x*", "axbc", -1, []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			got := MustCompile(tt.pattern).Split(tt.s, tt.n)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MustCompile(%q).Split(%q, %d) = %q; want %q", tt.pattern, tt.s, tt.n, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReplaceAllStringFunc(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		src      string
+		repl     func(string) string
+		expected string
+		calls    int
+	}{
+		{"\\w+", "hello, world 42", strings.ToUpper, "HELLO, WORLD 42", 3},
+		{"\\d", "a1b2", func(s string) string { return "<" + s + ">" }, "a<1>b<2>", 2},
+		{"x*", "abc", func(s string) string { return "-" }, "-a-b-c-", 4},
+		{"z", "abc", strings.ToUpper, "abc", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src+"_"+tt.pattern, func(t *testing.T) {
+			calls := 0
+			repl := func(s string) string {
+				calls++
+				return tt.repl(s)
+			}
+
+			got := MustCompile(tt.pattern).ReplaceAllStringFunc(tt.src, repl)
+			if got != tt.expected {
+				t.Errorf("MustCompile(%q).ReplaceAllStringFunc(%q) = %q; want %q", tt.pattern, tt.src, got, tt.expected)
+			}
+			if calls != tt.calls {
+				t.Errorf("MustCompile(%q).ReplaceAllStringFunc(%q) called repl %d times; want %d", tt.pattern, tt.src, calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestFindString(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected string
+		index    []int
+	}{
+		{"b+", "abbbc", "bbb", []int{1, 4}},
+		{"x*", "abc", "", []int{0, 0}},
+		{"z", "abc", "", nil},
+		{"é.", "caféx", "éx", []int{3, 6}},
+		{"(?:.*?)*1", " 11", " 1", []int{0, 2}},
+		{"(.*?|b??1*)+c", "bbacc", "bbac", []int{0, 4}},
+		{"(c*?.*?)*c", "0cc", "0cc", []int{0, 3}},
+		{"a(?:b?|c){1,}", "abc", "abc", []int{0, 3}},
+		{"a(?:b?|c)+", "abc", "abc", []int{0, 3}},
+		{"(?:b?|c){2,}", "bbc", "bbc", []int{0, 3}},
+		{"(?:b?|c){0,}", "bbc", "bbc", []int{0, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if got := re.FindString(tt.s); got != tt.expected {
+				t.Errorf("MustCompile(%q).FindString(%q) = %q; want %q", tt.pattern, tt.s, got, tt.expected)
+			}
+			if got := re.FindStringIndex(tt.s); !reflect.DeepEqual(got, tt.index) {
+				t.Errorf("MustCompile(%q).FindStringIndex(%q) = %v; want %v", tt.pattern, tt.s, got, tt.index)
+			}
+		})
+	}
+}
+
+func TestLongest(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		first    string
+		longest  string
+		submatch []string
+	}{
+		{"(a|ab)", "ab", "a", "ab", []string{"ab", "ab"}},
+		{"a|ab|abc", "xabcd", "a", "abc", []string{"abc"}},
+		{"(a|ab)(c|bcd)", "abcd", "abcd", "abcd", []string{"abcd", "a", "bcd"}},
+		{"(a+|b)(a*)", "aab", "aa", "aa", []string{"aa", "aa", ""}},
+		{"x*|y+", "yyy", "", "yyy", []string{"yyy"}},
+		{"(a|ab)\\1", "ababab", "abab", "abab", []string{"abab", "ab"}},
+		{"(?:cat|category)s?", "categorys", "cat", "categorys", []string{"categorys"}},
+		{"b|abc", "abc", "abc", "abc", []string{"abc"}},
+		{"|abc", "abc", "", "abc", []string{"abc"}},
+		{"z", "abc", "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if got := re.FindString(tt.s); got != tt.first {
+				t.Errorf("MustCompile(%q).FindString(%q) = %q; want %q", tt.pattern, tt.s, got, tt.first)
+			}
+
+			re.Longest()
+			if got := re.FindString(tt.s); got != tt.longest {
+				t.Errorf("Longest MustCompile(%q).FindString(%q) = %q; want %q", tt.pattern, tt.s, got, tt.longest)
+			}
+			if got := re.FindStringSubmatch(tt.s); !reflect.DeepEqual(got, tt.submatch) {
+				t.Errorf("Longest MustCompile(%q).FindStringSubmatch(%q) = %q; want %q", tt.pattern, tt.s, got, tt.submatch)
+			}
+		})
+	}
+
+	re := MustCompile("a|ab")
+	re.Longest()
+	if got := re.FindAllStringIndex("abab a", -1); !reflect.DeepEqual(got, [][]int{{0, 2}, {2, 4}, {5, 6}}) {
+		t.Errorf("Longest MustCompile(%q).FindAllStringIndex(%q) = %v", "a|ab", "abab a", got)
+	}
+}
+
+func TestFindStringSubmatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected []string
+		index    []int
+	}{
+		{"(\\w+) (\\w+)", "hello world", []string{"hello world", "hello", "world"}, []int{0, 11, 0, 5, 6, 11}},
+		{"a(b(c))", "xabc", []string{"abc", "bc", "c"}, []int{1, 4, 2, 4, 3, 4}},
+		{"(a|b)+", "abba", []string{"abba", "a"}, []int{0, 4, 3, 4}},
+		{"((a)|(b))", "b", []string{"b", "b", "", "b"}, []int{0, 1, 0, 1, -1, -1, 0, 1}},
+		{"(a)", "xyz", nil, nil},
+		{"(?:ab)+", "abab", []string{"abab"}, []int{0, 4}},
+		{"(a*)*", "aab", []string{"aa", "aa"}, []int{0, 2, 0, 2}},
+		{"(.*?|b??1*)+c", "bbacc", []string{"bbac", "a"}, []int{0, 4, 2, 3}},
+		{"(a|b??)*c", "abc", []string{"abc", "b"}, []int{0, 3, 1, 2}},
+		{"(a|b??){2,}?c", "abc", []string{"abc", "b"}, []int{0, 3, 1, 2}},
+		{"(a)?b", "b", []string{"b", ""}, []int{0, 1, -1, -1}},
+		{"(?:(a)?x)+", "axx", []string{"axx", "a"}, []int{0, 3, 0, 1}},
+		{"(?:a(b))(c)", "abc", []string{"abc", "b", "c"}, []int{0, 3, 1, 2, 2, 3}},
+		{"(?:x|(y))z", "xz", []string{"xz", ""}, []int{0, 2, -1, -1}},
+		{"(a)|(b)", "b", []string{"b", "", "b"}, []int{0, 1, -1, -1, 0, 1}},
+		{"a(b)|c(d)", "xcd", []string{"cd", "", "d"}, []int{1, 3, -1, -1, 2, 3}},
+		{"(a{2,4})", "aaaa", []string{"aaaa", "aaaa"}, []int{0, 4, 0, 4}},
+		{"(a{2,4}?)", "aaaa", []string{"aa", "aa"}, []int{0, 2, 0, 2}},
+		{"(a{2,4}?)b", "aaab", []string{"aaab", "aaa"}, []int{0, 4, 0, 3}},
+		{"(a{2,}?)(a*)", "aaaa", []string{"aaaa", "aa", "aa"}, []int{0, 4, 0, 2, 2, 4}},
+		{"(a+?)(a*)", "aaa", []string{"aaa", "a", "aa"}, []int{0, 3, 0, 1, 1, 3}},
+		{"(a*?)(a*)", "aaa", []string{"aaa", "", "aaa"}, []int{0, 3, 0, 0, 0, 3}},
+		{"(a??)(a*)", "aaa", []string{"aaa", "", "aaa"}, []int{0, 3, 0, 0, 0, 3}},
+		{"<(.+?)>", "<a><b>", []string{"<a>", "a"}, []int{0, 3, 1, 2}},
+		{"<(.+)>", "<a><b>", []string{"<a><b>", "a><b"}, []int{0, 6, 1, 5}},
+		{"((a)|b)++", "aab", []string{"aab", "b", "a"}, []int{0, 3, 2, 3, 1, 2}},
+		{"(a)?+a|b", "ab", []string{"b", ""}, []int{1, 2, -1, -1}},
+		{"a(?=(b))(\\w)", "xab", []string{"ab", "b", "b"}, []int{1, 3, 2, 3, 2, 3}},
+		{"(?!(a)x)(\\w)", "ab", []string{"a", "", "a"}, []int{0, 1, -1, -1, 0, 1}},
+		{"(?<=(a))b", "ab", []string{"b", "a"}, []int{1, 2, 0, 1}},
+		{"(\\w+) \\1", "x ab ab", []string{"ab ab", "ab"}, []int{2, 7, 2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s+"_"+tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if got := re.FindStringSubmatch(tt.s); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MustCompile(%q).FindStringSubmatch(%q) = %q; want %q", tt.pattern, tt.s, got, tt.expected)
+			}
+			if got := re.FindStringSubmatchIndex(tt.s); !reflect.DeepEqual(got, tt.index) {
+				t.Errorf("MustCompile(%q).FindStringSubmatchIndex(%q) = %v; want %v", tt.pattern, tt.s, got, tt.index)
+			}
+		})
+	}
+}
+
+func TestReplaceAllString(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		src      string
+		repl     string
+		expected string
+	}{
+		{"(\\w+) (\\w+)", "hello world", "$2 $1", "world hello"},
+		{"(\\w+) (\\w+)", "a b, c d", "${2}_${1}", "b_a, d_c"},
+		{"(\\d+)", "cost 5", "$$$1", "cost $5"},
+		{"(\\d+)", "cost 5", "$3", "cost "},
+		{"(\\d+)", "cost 5", "$1x", "cost "},
+		{"(\\d+)", "cost 5", "${1}x", "cost 5x"},
+		{"(\\d+)", "cost 5", "${name}", "cost "},
+		{"(\\d+)", "cost 5", "$!", "cost $!"},
+		{"(\\d+)", "cost 5", "$", "cost $"},
+		{"a(x)?", "ab", "[$1]", "[]b"},
+		{"(?P<year>\\d{4})-(?P<month>\\d{2})", "on 2024-05", "$month/${year}", "on 05/2024"},
+		{"(?P<year>\\d{4})", "on 2024", "$year$1", "on 20242024"},
+		{"(?P<year>\\d{4})", "on 2024", "$years", "on "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src+"_"+tt.repl, func(t *testing.T) {
+			got := MustCompile(tt.pattern).ReplaceAllString(tt.src, tt.repl)
+			if got != tt.expected {
+				t.Errorf("MustCompile(%q).ReplaceAllString(%q, %q) = %q; want %q", tt.pattern, tt.src, tt.repl, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReplaceStringN(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		src      string
+		repl     string
+		n        int
+		expected string
+	}{
+		{"a", "a a a", "X", 1, "X a a"},
+		{"a", "a a a", "X", 2, "X X a"},
+		{"a", "a a a", "X", 5, "X X X"},
+		{"a", "a a a", "X", -1, "X X X"},
+		{"a", "a a a", "X", 0, "a a a"},
+		{"(\\w)(\\d)", "a1 b2 c3", "$2$1", 2, "1a 2b c3"},
+		{"x*", "abc", "-", 2, "-a-bc"},
+	}
+
+	for _, tt := range tests {
+		got := MustCompile(tt.pattern).ReplaceStringN(tt.src, tt.repl, tt.n)
+		if got != tt.expected {
+			t.Errorf("MustCompile(%q).ReplaceStringN(%q, %q, %d) = %q; want %q", tt.pattern, tt.src, tt.repl, tt.n, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchStringContext(t *testing.T) {
+	re := MustCompile("b(?=c)")
+	if matched, err := re.MatchStringContext(context.Background(), "abc"); !matched || err != nil {
+		t.Errorf("MatchStringContext(%q) = %v, %v; want true, nil", "abc", matched, err)
+	}
+	if matched, err := re.MatchStringContext(context.Background(), "abd"); matched || err != nil {
+		t.Errorf("MatchStringContext(%q) = %v, %v; want false, nil", "abd", matched, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if matched, err := re.MatchStringContext(ctx, "abc"); matched || err != context.Canceled {
+		t.Errorf("MatchStringContext with a canceled context = %v, %v; want false, %v", matched, err, context.Canceled)
+	}
+
+	// A lookahead searched afresh from every position takes quadratic time, well over a second on this line.
+	slow := MustCompile("(?=(a|aa)*c)")
+	line := strings.Repeat("a", 3000)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	matched, err := slow.MatchStringContext(ctx, line)
+	if matched || err != context.DeadlineExceeded {
+		t.Errorf("MatchStringContext past the deadline = %v, %v; want false, %v", matched, err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("MatchStringContext returned %v after the deadline", elapsed)
+	}
+}
+
+func TestMatchStringWithLimit(t *testing.T) {
+	re := MustCompile("b(?=c)")
+	if matched, err := re.MatchStringWithLimit("abc", 100); !matched || err != nil {
+		t.Errorf("MatchStringWithLimit(%q, 100) = %v, %v; want true, nil", "abc", matched, err)
+	}
+	if matched, err := re.MatchStringWithLimit("abd", 100); matched || err != nil {
+		t.Errorf("MatchStringWithLimit(%q, 100) = %v, %v; want false, nil", "abd", matched, err)
+	}
+
+	// The steps of the lookahead count too, and a limit of zero means none.
+	slow := MustCompile("(?=(a|aa)*c)")
+	line := strings.Repeat("a", 300)
+	if matched, err := slow.MatchStringWithLimit(line, 1000); matched || err != ErrStepLimit {
+		t.Errorf("MatchStringWithLimit over the limit = %v, %v; want false, %v", matched, err, ErrStepLimit)
+	}
+	if matched, err := slow.MatchStringWithLimit(line, 0); matched || err != nil {
+		t.Errorf("MatchStringWithLimit without a limit = %v, %v; want false, nil", matched, err)
+	}
+	if matched, err := slow.MatchStringWithLimit("aac", 1000); !matched || err != nil {
+		t.Errorf("MatchStringWithLimit(%q, 1000) = %v, %v; want true, nil", "aac", matched, err)
+	}
+}
+
+func TestMatchStringLongLine(t *testing.T) {
+	line := strings.Repeat("ab", 500000) + "needle"
+	tests := []struct {
+		pattern  string
+		expected bool
+	}{
+		{"needle", true},
+		{"needle$", true},
+		{"b+needle", true},
+		{"(ab)*needle", true},
+		{"haystack", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if result := MustCompile(tt.pattern).MatchString(line); result != tt.expected {
+				t.Errorf("MatchString(%q) = %v; want %v", tt.pattern, result, tt.expected)
+			}
+		})
+	}
+}
+
+// benchmarkLine is a long line that the benchmark pattern does not match.
+var benchmarkLine = strings.Repeat("the quick brown fox jumps over the lazy dog ", 100)
+
+func BenchmarkMatchPerOffset(b *testing.B) {
+	re := MustCompile("lazy cat")
+	input := stringSource(benchmarkLine)
+	for i := 0; i < b.N; i++ {
+		m := &machine{}
+		m.visits.reset(re.numStates, len(input))
+		for pos := bosWidth; pos < len(input); pos++ {
+			if _, ok := m.matches(re.nfa, input, pos, nil, false); ok {
+				b.Fatal("unexpected match")
+			}
+		}
+	}
+}
+
+func BenchmarkMatchPrefixed(b *testing.B) {
+	re := MustCompile("lazy cat")
+	for i := 0; i < b.N; i++ {
+		if re.MatchString(benchmarkLine) {
+			b.Fatal("unexpected match")
+		}
+	}
+}
+
+// BenchmarkFindAllLongLine finds every match in lines of growing length. Each match should cost the same
+// whatever the length of the line, so the throughput stays flat as the line grows.
+func BenchmarkFindAllLongLine(b *testing.B) {
+	re := MustCompile("a")
+	for _, n := range []int{1000, 16000} {
+		line := strings.Repeat("a ", n)
+		b.Run("Matches="+strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(len(line)))
+			for i := 0; i < b.N; i++ {
+				if got := len(re.FindAllStringIndex(line, -1)); got != n {
+					b.Fatalf("found %d matches; want %d", got, n)
+				}
+			}
+		})
+	}
+}
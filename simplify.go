@@ -0,0 +1,140 @@
+package re
+
+// simplify returns the tokens of a pattern, as returned by Parse, with nested quantifiers collapsed into one,
+// so that "a**", "a+*" and "(?:a?)+" all become "a*" and "(?:a+)+" becomes "a+". The result matches the same
+// text as the original, but its NFA has fewer states and fewer epsilon cycles to walk.
+//
+// Only greedy quantifiers over a payload without capturing groups are collapsed: a lazy quantifier prefers
+// a different match, and a capturing group could report a different iteration. The tokens are not modified.
+func simplify(tokens []Token) []Token {
+	simplified := make([]Token, len(tokens))
+	for i, token := range tokens {
+		simplified[i] = simplifyToken(token)
+	}
+	return simplified
+}
+
+// simplifyAlternatives simplifies each alternative of a group.
+func simplifyAlternatives(payload [][]Token) [][]Token {
+	simplified := make([][]Token, len(payload))
+	for i, tokens := range payload {
+		simplified[i] = simplify(tokens)
+	}
+	return simplified
+}
+
+// simplifyToken simplifies the tokens inside a token first, then the token itself if it is a quantifier.
+func simplifyToken(token Token) Token {
+	switch t := token.(type) {
+	case plusToken:
+		t.payload = simplifyToken(t.payload)
+		return collapseQuantifier(t)
+	case starToken:
+		t.payload = simplifyToken(t.payload)
+		return collapseQuantifier(t)
+	case optionalToken:
+		t.payload = simplifyToken(t.payload)
+		return collapseQuantifier(t)
+	case repeatToken:
+		t.payload = simplifyToken(t.payload)
+		return t
+	case possessiveToken:
+		t.payload = simplifyToken(t.payload)
+		return t
+	case groupToken:
+		t.payload = simplifyAlternatives(t.payload)
+		return t
+	case atomicGroupToken:
+		t.payload = simplifyAlternatives(t.payload)
+		return t
+	case lookaheadToken:
+		t.payload = simplifyAlternatives(t.payload)
+		return t
+	case lookbehindToken:
+		t.payload = simplifyAlternatives(t.payload)
+		return t
+	case conditionalToken:
+		t.payload = simplifyAlternatives(t.payload)
+		return t
+	}
+	return token
+}
+
+// collapseQuantifier merges a greedy '?', '*' or '+' whose payload is itself a greedy '?', '*' or '+',
+// possibly wrapped in a non-capturing group of a single token, into a single quantifier over the inner payload:
+// two '+' make a '+', two '?' make a '?', and any other pair makes a '*'. Any other token is returned as it is.
+func collapseQuantifier(token Token) Token {
+	outer, inner, lazy := quantifier(token)
+	if outer == 0 || lazy {
+		return token
+	}
+	if group, ok := inner.(groupToken); ok && !group.capturing && len(group.payload) == 1 && len(group.payload[0]) == 1 {
+		inner = group.payload[0][0]
+	}
+
+	innerOp, payload, innerLazy := quantifier(inner)
+	if innerOp == 0 || innerLazy || hasCapture(payload) {
+		return token
+	}
+
+	switch {
+	case outer == '+' && innerOp == '+':
+		return plusToken{payload: payload}
+	case outer == '?' && innerOp == '?':
+		return optionalToken{payload: payload}
+	}
+	return starToken{payload: payload}
+}
+
+// quantifier returns the operator of a '?', '*' or '+' token, its payload and whether it is lazy,
+// or 0 as the operator for any other token.
+func quantifier(token Token) (byte, Token, bool) {
+	switch t := token.(type) {
+	case optionalToken:
+		return '?', t.payload, t.lazy
+	case starToken:
+		return '*', t.payload, t.lazy
+	case plusToken:
+		return '+', t.payload, t.lazy
+	}
+	return 0, nil, false
+}
+
+// hasCapture reports whether a token is or contains a capturing group.
+func hasCapture(token Token) bool {
+	switch t := token.(type) {
+	case plusToken:
+		return hasCapture(t.payload)
+	case starToken:
+		return hasCapture(t.payload)
+	case optionalToken:
+		return hasCapture(t.payload)
+	case repeatToken:
+		return hasCapture(t.payload)
+	case possessiveToken:
+		return hasCapture(t.payload)
+	case groupToken:
+		return t.capturing || alternativesHaveCapture(t.payload)
+	case atomicGroupToken:
+		return alternativesHaveCapture(t.payload)
+	case lookaheadToken:
+		return alternativesHaveCapture(t.payload)
+	case lookbehindToken:
+		return alternativesHaveCapture(t.payload)
+	case conditionalToken:
+		return alternativesHaveCapture(t.payload)
+	}
+	return false
+}
+
+// alternativesHaveCapture reports whether any token of the alternatives of a group is or contains a capturing group.
+func alternativesHaveCapture(payload [][]Token) bool {
+	for _, tokens := range payload {
+		for _, token := range tokens {
+			if hasCapture(token) {
+				return true
+			}
+		}
+	}
+	return false
+}
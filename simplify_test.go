@@ -0,0 +1,72 @@
+package re
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"a**", "a*"},
+		{"a+*", "a*"},
+		{"a*+", "a*+"},
+		{"a***", "a*"},
+		{"(?:a?)?", "a?"},
+		{"(?:a?)+", "a*"},
+		{"(?:a+)+", "a+"},
+		{"(?:a+)?", "a*"},
+		{"(?:ab*)*", "(?:ab*)*"},
+		{"(?:(?:ab)+)*", "(?:ab)*"},
+		{"x(?:[a-c]*)*y", "x[a-c]*y"},
+		{"(a?)?", "(a?)?"},
+		{"(?:(a)*)*", "(?:(a)*)*"},
+		{"a*?*", "a*?*"},
+		{"(?:a*)*?", "(?:a*)*?"},
+		{"a{2}*", "a{2}*"},
+		{"(?=(?:a+)+)b|(?>c**)", "(?:(?=a+)b|(?>c*))"},
+	}
+
+	for _, tt := range tests {
+		tokens, err := Parse(tt.pattern)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.pattern, err)
+		}
+		if got := joinTokens(simplify(tokens)); got != tt.want {
+			t.Errorf("simplify(%q) = %q; want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// compileUnsimplified compiles pattern like Compile, but from the tokens as parsed, without simplifying them.
+func compileUnsimplified(pattern string) *Regexp {
+	re := MustCompile(pattern)
+	tokens, _ := Parse(pattern)
+	re.tokens, re.nfa = tokens, buildNfa(tokens)
+	re.searchNfa = re.nfa.unanchored()
+	re.numStates = re.searchNfa.numberStates()
+	re.refs = re.nfa.backrefSlots()
+	re.simple = dfaEligible(re.searchNfa)
+	re.prefix, re.complete = literalPrefix(tokens)
+	return re
+}
+
+func TestSimplifyKeepsMatches(t *testing.T) {
+	patterns := []string{"a**", "a+*b", "(?:a?)+b", "(?:a+)+$", "x(?:a?)?a", "(?:(?:ab)+)*c", "(a)(?:b?)+\\1"}
+	inputs := []string{"", "a", "b", "aab", "xa", "xaa", "ababc", "abbba", "cab", "aaaa"}
+
+	for _, pattern := range patterns {
+		simplified, unsimplified := MustCompile(pattern), compileUnsimplified(pattern)
+		if simplified.numStates > unsimplified.numStates {
+			t.Errorf("%q: %d states after simplifying; want at most %d", pattern, simplified.numStates, unsimplified.numStates)
+		}
+		for _, input := range inputs {
+			got, want := simplified.FindAllStringSubmatchIndex(input, -1), unsimplified.FindAllStringSubmatchIndex(input, -1)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%q on %q: got %v; want %v", pattern, input, got, want)
+			}
+		}
+	}
+}